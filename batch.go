@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// job pairs an input EPUB with its output path; an empty output means
+// the default derived from the input filename
+type job struct {
+	input  string
+	output string
+}
+
+// convertBatch converts the jobs with up to workers files in progress at
+// once, continuing past failures, and returns the errors of those that
+// failed. The progress line stands in for the per-file messages; each
+// file's warnings are held until it is done and then printed together,
+// prefixed with its name, so that files converting at the same time
+// don't interleave.
+func convertBatch(jobs []job, s *settings, workers int) []error {
+	workers = min(max(workers, 1), len(jobs))
+	p := newProgress(len(jobs), s.quiet)
+
+	queue := make(chan job)
+	go func() {
+		for _, j := range jobs {
+			queue <- j
+		}
+		close(queue)
+	}()
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				p.next(j.input)
+				messages, err := convertQuietly(j, s, workers > 1)
+				if err != nil {
+					messages += fmt.Sprintf("Warning: failed to convert %s: %v\n", j.input, err)
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+				if messages != "" {
+					p.print(messages)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	p.clear()
+	return errs
+}
+
+// convertQuietly converts a single job of a batch, returning the
+// warnings and other messages for it with each line prefixed by the
+// input's name. Files converted in parallel extract their chapters one
+// at a time, since the CPUs are already busy with other files.
+func convertQuietly(j job, s *settings, parallel bool) (string, error) {
+	var messages bytes.Buffer
+	fileSettings := *s
+	fileSettings.quiet = true
+	fileSettings.stderr = &messages
+	fileSettings.opts.Warnings = &messages
+	if parallel {
+		fileSettings.opts.Jobs = 1
+	}
+	if logger := s.opts.Logger; logger != nil {
+		fileSettings.opts.Logger = log.New(logger.Writer(), logger.Prefix()+j.input+": ", logger.Flags())
+	}
+
+	err := convertOne(j.input, j.output, &fileSettings)
+
+	var prefixed strings.Builder
+	scanner := bufio.NewScanner(&messages)
+	for scanner.Scan() {
+		fmt.Fprintf(&prefixed, "%s: %s\n", j.input, scanner.Text())
+	}
+	return prefixed.String(), err
+}
+
+// collectJobs turns the command line inputs into conversion jobs,
+// walking directories for EPUBs when recursive is set; outputExt is the
+// extension given to the outputs of books found this way
+func collectJobs(inputs []string, recursive bool, outputExt string) ([]job, error) {
+	var jobs []job
+	for _, input := range inputs {
+		if input == "-" {
+			jobs = append(jobs, job{input: input})
+			continue
+		}
+
+		info, err := os.Stat(input)
+		if err != nil || !info.IsDir() {
+			// Let the conversion itself report missing files
+			jobs = append(jobs, job{input: input})
+			continue
+		}
+
+		// A directory holding an unpacked EPUB is converted as one book
+		if isUnpackedEpub(input) {
+			jobs = append(jobs, job{input: input})
+			continue
+		}
+
+		if !recursive {
+			return nil, fmt.Errorf("%s is a directory (use -recursive to convert its contents)", input)
+		}
+
+		books, err := findEpubs(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, book := range books {
+			// Write each text file next to its source book
+			output := strings.TrimSuffix(book, filepath.Ext(book)) + outputExt
+			jobs = append(jobs, job{input: book, output: output})
+		}
+	}
+	return jobs, nil
+}
+
+// templateField matches a {placeholder} in an output template
+var templateField = regexp.MustCompile(`\{[^{}]*\}`)
+
+// checkOutputTemplate rejects templates with unknown placeholders or
+// that would write every input to the same file
+func checkOutputTemplate(tmpl string) error {
+	for _, field := range templateField.FindAllString(tmpl, -1) {
+		switch field {
+		case "{dir}", "{name}", "{ext}":
+		default:
+			return fmt.Errorf("unknown placeholder %s in output template (use {dir}, {name}, or {ext})", field)
+		}
+	}
+	if !strings.Contains(tmpl, "{name}") {
+		return fmt.Errorf("output template must contain {name}")
+	}
+	return nil
+}
+
+// expandOutputTemplate derives an output path for input from tmpl:
+// {dir} is the input's directory, {name} its filename without the
+// extension, and {ext} the extension without its dot
+func expandOutputTemplate(tmpl, input string) string {
+	base := filepath.Base(input)
+	ext := filepath.Ext(base)
+	return strings.NewReplacer(
+		"{dir}", filepath.Dir(input),
+		"{name}", strings.TrimSuffix(base, ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+	).Replace(tmpl)
+}
+
+// isUnpackedEpub reports whether dir contains the META-INF/container.xml
+// of an unzipped EPUB
+func isUnpackedEpub(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, "META-INF", "container.xml"))
+	return err == nil && !info.IsDir()
+}
+
+// findEpubs returns every .epub file beneath dir in lexical order
+func findEpubs(dir string) ([]string, error) {
+	var books []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", path, err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// WalkDir never follows symlinks, but skip them explicitly so a
+		// linked directory can't cause a loop or duplicate conversions
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".epub") {
+			books = append(books, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", dir, err)
+	}
+	return books, nil
+}
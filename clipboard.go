@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// clipboardCommand returns the command that copies its standard input to
+// the system clipboard on this platform
+func clipboardCommand() (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("pbcopy"), nil
+	case "linux", "freebsd", "openbsd", "netbsd", "dragonfly":
+		// Wayland and X11 each have their own clipboard tools
+		candidates := [][]string{
+			{"xclip", "-selection", "clipboard"},
+			{"xsel", "--clipboard", "--input"},
+		}
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append([][]string{{"wl-copy"}}, candidates...)
+		}
+		for _, args := range candidates {
+			if path, err := exec.LookPath(args[0]); err == nil {
+				return exec.Command(path, args[1:]...), nil
+			}
+		}
+		return nil, errors.New("no clipboard command found (install wl-copy, xclip, or xsel)")
+	default:
+		return nil, fmt.Errorf("-clipboard is not supported on %s", runtime.GOOS)
+	}
+}
+
+// copyToClipboard replaces the contents of the system clipboard with text
+func copyToClipboard(text []byte) error {
+	cmd, err := clipboardCommand()
+	if err != nil {
+		return fmt.Errorf("failed to copy to clipboard: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(text)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return fmt.Errorf("failed to copy to clipboard: %w: %s", err, msg)
+		}
+		return fmt.Errorf("failed to copy to clipboard: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultConfig is the config file read when -config is not given,
+// relative to the user's home directory
+const defaultConfig = ".epub2text.toml"
+
+// applyConfig sets each flag named in the config file at path that was
+// not given on the command line, so the command line always wins. An
+// empty path reads ~/.epub2text.toml if it exists.
+func applyConfig(path string) error {
+	explicit := path != ""
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, defaultConfig)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	values, err := parseConfig(file, path)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if given[v.name] {
+			continue
+		}
+		if err := flag.Set(v.name, v.value); err != nil {
+			return fmt.Errorf("%s:%d: invalid value %q for %s: %w", path, v.line, v.value, v.name, err)
+		}
+	}
+	return nil
+}
+
+// configValue is a single flag setting read from a config file
+type configValue struct {
+	name, value string
+	line        int
+}
+
+// parseConfig reads the "name = value" lines of a config file, a subset
+// of TOML in which each name is a flag. Values may be quoted, and blank
+// lines and # comments are ignored.
+func parseConfig(file *os.File, path string) ([]configValue, error) {
+	var values []configValue
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected name = value", path, line)
+		}
+		name = strings.TrimSpace(name)
+		if name == "config" || flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s:%d: unknown flag %q", path, line, name)
+		}
+
+		value, err := configString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		values = append(values, configValue{name: name, value: value, line: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return values, nil
+}
+
+// configString unquotes a TOML string value, dropping any comment after
+// it; bare values such as numbers and booleans are used as written
+func configString(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		for i := 1; i < len(value); i++ {
+			switch value[i] {
+			case '\\':
+				i++
+			case '"':
+				return strconv.Unquote(value[:i+1])
+			}
+		}
+		return "", fmt.Errorf("unterminated string %s", value)
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return value[1 : end+1], nil
+	}
+	if before, _, ok := strings.Cut(value, "#"); ok {
+		value = strings.TrimSpace(before)
+	}
+	return value, nil
+}
@@ -0,0 +1,84 @@
+package epub
+
+import (
+	"path"
+	"strings"
+)
+
+// anchorMark starts the line written where a chapter anchor appears in
+// the text, followed by the anchor's id
+const anchorMark = "\x01"
+
+// tocAnchor is a table of contents entry pointing into a content file
+type tocAnchor struct {
+	id    string
+	title string
+}
+
+// anchorsFor returns the fragment ids that table of contents entries
+// point to within the content file of href, in table of contents order
+func anchorsFor(entries []TOCEntry, href string) []tocAnchor {
+	target := path.Clean(hrefPath(href))
+	var anchors []tocAnchor
+	for _, entry := range entries {
+		entryPath, fragment, _ := strings.Cut(entry.Href, "#")
+		if fragment == "" || path.Clean(hrefPath(entryPath)) != target {
+			continue
+		}
+		anchors = append(anchors, tocAnchor{id: hrefPath(fragment), title: entry.Title})
+	}
+	return anchors
+}
+
+// section is the part of a content file's text from one anchor to the next
+type section struct {
+	id   string // empty for the text before the first anchor
+	text string
+}
+
+// splitSections splits text extracted with anchor marks at each mark
+func splitSections(text string) []section {
+	var sections []section
+	current := section{}
+	var lines []string
+	flush := func() {
+		current.text = strings.Trim(strings.Join(lines, "\n"), "\n")
+		if current.text != "" || current.id != "" {
+			sections = append(sections, current)
+		}
+		lines = nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if id, ok := strings.CutPrefix(line, anchorMark); ok {
+			flush()
+			current = section{id: id}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return sections
+}
+
+// warnSingleFile warns when every spine item is the same content file
+// while the table of contents points at several places within it, since
+// the output then has no chapter breaks. The table of contents is read
+// if entries is nil.
+func warnSingleFile(arc archive, pkg *Package, items []spineItem, entries []TOCEntry, baseDir string, opts Options) {
+	if len(items) == 0 {
+		return
+	}
+	first := zipPath(resolveHref(baseDir, items[0].Href))
+	for _, item := range items[1:] {
+		if zipPath(resolveHref(baseDir, item.Href)) != first {
+			return
+		}
+	}
+
+	if entries == nil {
+		entries, _ = readTOC(arc, pkg, baseDir, opts)
+	}
+	if anchors := anchorsFor(entries, items[0].Href); len(anchors) > 1 {
+		opts.warnf("the whole book is in %s, which the table of contents divides into %d chapters (split it with -split-anchors)", first, len(anchors))
+	}
+}
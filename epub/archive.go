@@ -0,0 +1,235 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// archive gives access to the files of an EPUB, whether they are packed
+// in a ZIP file or unpacked into a directory
+type archive interface {
+	// names lists every file in the archive by its stored path
+	names() []string
+
+	// open returns a reader for a file named as returned by names
+	open(name string) (io.ReadCloser, error)
+}
+
+// archiveFile is a single file within an archive
+type archiveFile struct {
+	arc  archive
+	Name string
+}
+
+// Open returns a reader for the file's contents
+func (f *archiveFile) Open() (io.ReadCloser, error) {
+	return f.arc.open(f.Name)
+}
+
+// dumpFile copies the file's contents to its archive path beneath dir
+func dumpFile(f *archiveFile, dir string) error {
+	dest := filepath.Join(dir, filepath.FromSlash(zipPath(f.Name)))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("failed to dump %s: %w", f.Name, err)
+	}
+
+	reader, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to dump %s: %w", f.Name, err)
+	}
+	defer reader.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to dump %s: %w", f.Name, err)
+	}
+	_, err = io.Copy(out, reader)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to dump %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// findFile returns the archive file with the given name, or nil if absent.
+// Names that differ only in case match when there is no exact match, as
+// some books were packaged on case-insensitive file systems.
+func findFile(arc archive, name string) *archiveFile {
+	// Normalize paths for comparison
+	name = zipPath(name)
+	var folded *archiveFile
+	for _, fileName := range arc.names() {
+		normalized := zipPath(fileName)
+		if normalized == name {
+			return &archiveFile{arc: arc, Name: fileName}
+		}
+		if folded == nil && strings.EqualFold(normalized, name) {
+			folded = &archiveFile{arc: arc, Name: fileName}
+		}
+	}
+	return folded
+}
+
+// limitedArchive fails reads once a file, or all the files read
+// together, decompress to more than max bytes. This guards against ZIP
+// bombs whose small archives expand to exhaust memory.
+type limitedArchive struct {
+	archive
+	max   int64
+	total *atomic.Int64
+}
+
+// limitArchive wraps arc to enforce a limit of max uncompressed bytes;
+// a limit of 0 or less leaves arc unlimited
+func limitArchive(arc archive, max int64) archive {
+	if max <= 0 {
+		return arc
+	}
+	return &limitedArchive{archive: arc, max: max, total: new(atomic.Int64)}
+}
+
+func (l *limitedArchive) open(name string) (io.ReadCloser, error) {
+	reader, err := l.archive.open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReader{ReadCloser: reader, arc: l, name: name}, nil
+}
+
+// limitedReader counts the bytes read from one file of a limitedArchive
+type limitedReader struct {
+	io.ReadCloser
+	arc  *limitedArchive
+	name string
+	read int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	l.read += int64(n)
+	if l.read > l.arc.max {
+		return n, fmt.Errorf("%s: %w (%d bytes)", l.name, ErrTooLarge, l.arc.max)
+	}
+	if l.arc.total.Add(int64(n)) > l.arc.max {
+		return n, fmt.Errorf("%w in total (%d bytes)", ErrTooLarge, l.arc.max)
+	}
+	return n, err
+}
+
+// zipArchive reads files from a ZIP archive
+type zipArchive struct {
+	reader *zip.Reader
+	files  map[string]*zip.File
+}
+
+func newZipArchive(reader *zip.Reader) *zipArchive {
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, file := range reader.File {
+		files[file.Name] = file
+	}
+	return &zipArchive{reader: reader, files: files}
+}
+
+func (z *zipArchive) names() []string {
+	names := make([]string, len(z.reader.File))
+	for i, file := range z.reader.File {
+		names[i] = file.Name
+	}
+	return names
+}
+
+func (z *zipArchive) open(name string) (io.ReadCloser, error) {
+	file, ok := z.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return file.Open()
+}
+
+// dirArchive reads files from a directory holding an unpacked EPUB
+type dirArchive struct {
+	root  string
+	files []string
+}
+
+func newDirArchive(root string) (*dirArchive, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EPUB directory: %w", err)
+	}
+	return &dirArchive{root: root, files: files}, nil
+}
+
+func (d *dirArchive) names() []string {
+	return d.files
+}
+
+func (d *dirArchive) open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.root, filepath.FromSlash(name)))
+}
+
+// openPath opens the EPUB at path, which may be a ZIP file or a
+// directory containing an unpacked EPUB. The returned function releases
+// any resources held by the archive.
+func openPath(path string) (archive, func() error, error) {
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		arc, err := newDirArchive(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return arc, func() error { return nil }, nil
+	}
+
+	// Open the EPUB file (which is a ZIP archive)
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		if file, openErr := os.Open(path); openErr == nil {
+			defer file.Close()
+			if mobiErr := checkMOBI(file); mobiErr != nil {
+				err = mobiErr
+			}
+		}
+		return nil, nil, fmt.Errorf("failed to open EPUB file: %w", err)
+	}
+	return newZipArchive(&reader.Reader), reader.Close, nil
+}
+
+// readZip buffers r in memory and opens it as a ZIP archive
+func readZip(r io.Reader) (archive, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EPUB: %w", err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		if mobiErr := checkMOBI(bytes.NewReader(data)); mobiErr != nil {
+			err = mobiErr
+		}
+		return nil, fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	return newZipArchive(reader), nil
+}
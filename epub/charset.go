@@ -0,0 +1,169 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+var (
+	// xmlEncoding matches the encoding in an XML declaration
+	xmlEncoding = regexp.MustCompile(`^\s*<\?xml[^>]*?\bencoding\s*=\s*["']([^"']+)["']`)
+
+	// metaCharset matches <meta charset> and the charset parameter of
+	// <meta http-equiv="Content-Type" content="...">
+	metaCharset = regexp.MustCompile(`(?i)<meta\b[^>]*?\bcharset\s*=\s*["']?([\w.:-]+)`)
+)
+
+// utf8BOM is the UTF-8 encoding of the byte order mark U+FEFF
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// declaredCharset returns the lowercased encoding named by a byte order
+// mark, XML declaration, or <meta> charset at the start of data, or ""
+// when none is declared
+func declaredCharset(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, utf8BOM):
+		return "utf-8"
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	}
+
+	// Declarations belong near the top of the document
+	head := data[:min(len(data), 1024)]
+	if m := xmlEncoding.FindSubmatch(head); m != nil {
+		return strings.ToLower(string(m[1]))
+	}
+	if m := metaCharset.FindSubmatch(head); m != nil {
+		return strings.ToLower(string(m[1]))
+	}
+	return ""
+}
+
+// toUTF8 transcodes data from its declared encoding to UTF-8, looking
+// the label up as browsers do, so Latin-1 is read as Windows-1252.
+// Documents without a declaration, or declaring an unsupported encoding,
+// are assumed to be UTF-8 already. Bytes that cannot be decoded become
+// U+FFFD, and how many were replaced is logged.
+func toUTF8(data []byte, opts Options) []byte {
+	var replaced int
+	label := declaredCharset(data)
+	enc, name := charset.Lookup(label)
+	switch {
+	case label == "" || name == "utf-8":
+		data, replaced = validUTF8(trimBOM(data))
+	case enc == nil:
+		opts.warnf("unsupported character encoding %q; reading as UTF-8", label)
+		data, replaced = validUTF8(data)
+	default:
+		decoded, err := enc.NewDecoder().Bytes(data)
+		if err != nil {
+			opts.warnf("failed to decode %s text: %v; reading as UTF-8", name, err)
+			data, replaced = validUTF8(data)
+			break
+		}
+		replaced = max(bytes.Count(decoded, replacementChar)-encodedReplacements(enc, data), 0)
+		data = trimBOM(decoded)
+	}
+	if replaced > 0 {
+		opts.logf("replaced %d undecodable byte sequences with U+FFFD", replaced)
+	}
+	return data
+}
+
+// replacementChar is the UTF-8 encoding of U+FFFD, which decoders put in
+// place of the bytes they cannot decode
+var replacementChar = []byte(string(utf8.RuneError))
+
+// encodedReplacements counts the U+FFFD characters that data, in enc,
+// holds itself, so they are not reported as bytes that failed to decode
+func encodedReplacements(enc encoding.Encoding, data []byte) int {
+	char, err := enc.NewEncoder().Bytes(replacementChar)
+	if err != nil || bytes.HasPrefix(char, []byte("&#")) {
+		// The encoding has no U+FFFD, and writes a character reference instead
+		return 0
+	}
+	return bytes.Count(data, char)
+}
+
+// trimBOM removes the UTF-8 byte order marks at the start of data.
+// Files re-saved by some editors carry more than one.
+func trimBOM(data []byte) []byte {
+	for bytes.HasPrefix(data, utf8BOM) {
+		data = data[len(utf8BOM):]
+	}
+	return data
+}
+
+// validUTF8 replaces each invalid byte in data with U+FFFD, returning the
+// result and the number of replacements
+func validUTF8(data []byte) ([]byte, int) {
+	if utf8.Valid(data) {
+		return data, 0
+	}
+
+	out := make([]byte, 0, len(data)+8)
+	replaced := 0
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			replaced++
+		}
+		out = utf8.AppendRune(out, r)
+		data = data[size:]
+	}
+	return out, replaced
+}
+
+// parseHTML parses an HTML document after transcoding it to UTF-8. Tag
+// names are lowercased throughout, since the parser keeps the case of
+// elements it treats as foreign content.
+func parseHTML(r io.Reader, opts Options) (*html.Node, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseUTF8HTML(toUTF8(data, opts))
+}
+
+// parseUTF8HTML parses an HTML document already transcoded by toUTF8,
+// lowercasing its tag names as parseHTML does
+func parseUTF8HTML(data []byte) (*html.Node, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	lowercaseTags(doc)
+	return doc, nil
+}
+
+func lowercaseTags(n *html.Node) {
+	if n.Type == html.ElementNode {
+		n.Data = strings.ToLower(n.Data)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		lowercaseTags(c)
+	}
+}
+
+// unmarshalXML decodes an XML document after transcoding it to UTF-8,
+// which also drops any byte order mark before the XML declaration, as
+// container.xml and package documents written by some tools have
+func unmarshalXML(data []byte, v any, opts Options) error {
+	decoder := xml.NewDecoder(bytes.NewReader(toUTF8(data, opts)))
+
+	// The input is UTF-8 by now whatever its declaration says
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return decoder.Decode(v)
+}
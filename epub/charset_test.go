@@ -0,0 +1,87 @@
+package epub
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestParseHTMLLowercasesTags(t *testing.T) {
+	doc, err := parseHTML(strings.NewReader(xhtml(
+		`<DIV><P>text</P><svg><foreignObject><P>inside</P></foreignObject><linearGradient/></svg></DIV>`,
+	)), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var check func(n *html.Node)
+	check = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data != strings.ToLower(n.Data) {
+			t.Errorf("element %q was not lowercased", n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			check(c)
+		}
+	}
+	check(doc)
+}
+
+func TestExtractUppercaseTags(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"p and div", "<P>one</P><DIV>two</DIV>", "one\n\ntwo"},
+		{"mixed case", "<Div>one</Div><H2>two</H2><Br/>three", "one\n\ntwo\n\nthree"},
+		{"list", "<UL><LI>one</LI><LI>two</LI></UL>", "- one\n- two"},
+		{"mathml text", "<math><mtext><DIV>one</DIV><P>two</P></mtext></math>", "one\n\ntwo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractHTML(t, tt.body, Options{}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		want   string
+		logged string // part of the log or warnings expected, if any
+	}{
+		{"undeclared", "caf\xc3\xa9", "café", ""},
+		{"utf-8 with BOM", "\xef\xbb\xbf<?xml version=\"1.0\" encoding=\"UTF-8\"?>é", `<?xml version="1.0" encoding="UTF-8"?>é`, ""},
+		{"invalid utf-8", "a\xffb\xfe", "a\ufffdb\ufffd", "replaced 2 undecodable"},
+		{"windows-1252", `<?xml version="1.0" encoding="windows-1252"?>` + "\x93caf\xe9\x94 \x80", `<?xml version="1.0" encoding="windows-1252"?>` + "“café” €", ""},
+		{"latin-1 read as windows-1252", `<meta charset="ISO-8859-1">` + "\x85", `<meta charset="ISO-8859-1">` + "…", ""},
+		{"utf-16le with BOM", "\xff\xfeh\x00i\x00", "hi", ""},
+		{"utf-16be with BOM", "\xfe\xff\x00h\x00i", "hi", ""},
+		{"utf-8 with its own U+FFFD", "a\ufffdb\xff", "a\ufffdb\ufffd", "replaced 1 undecodable"},
+		{"utf-16le with its own U+FFFD", "\xff\xfea\x00\xfd\xff", "a\ufffd", ""},
+		{"utf-16le with its own U+FFFD and a lone surrogate", "\xff\xfea\x00\xfd\xff\x00\xd8b\x00", "a\ufffd\ufffdb", "replaced 1 undecodable"},
+		{"windows-1252 character reference", `<meta charset="windows-1252">&#65533;`, `<meta charset="windows-1252">&#65533;`, ""},
+		{"unknown label", `<?xml version="1.0" encoding="x-made-up"?>é`, `<?xml version="1.0" encoding="x-made-up"?>é`, `unsupported character encoding "x-made-up"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			opts := Options{Logger: log.New(&logged, "", 0), Warnings: &logged}
+			if got := string(toUTF8([]byte(tt.data), opts)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(logged.String(), tt.logged) {
+				t.Errorf("log %q does not contain %q", logged.String(), tt.logged)
+			}
+			if tt.logged == "" && strings.Contains(logged.String(), "replaced") {
+				t.Errorf("log %q reports replacements", logged.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package epub
+
+import (
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// coverPages is how many spine items from the start are searched for the
+// page showing the cover image
+const coverPages = 3
+
+// coverItem returns the manifest item of the cover image, marked with the
+// EPUB 3 cover-image property or named by the EPUB 2 <meta name="cover">
+func coverItem(pkg *Package) *Item {
+	for i, item := range pkg.Manifest.Items {
+		if item.HasProperty("cover-image") {
+			return &pkg.Manifest.Items[i]
+		}
+	}
+
+	for _, meta := range pkg.Metadata.Metas {
+		if meta.Name != "cover" {
+			continue
+		}
+		for i, item := range pkg.Manifest.Items {
+			if item.ID == meta.Content {
+				return &pkg.Manifest.Items[i]
+			}
+		}
+	}
+	return nil
+}
+
+// readCoverText returns the alt text or title of the cover image, taken
+// from the page that displays it, or "" if the book has no described cover
+func readCoverText(arc archive, pkg *Package, baseDir string, opts Options) string {
+	cover := coverItem(pkg)
+	if cover == nil {
+		return ""
+	}
+	coverPath := resolveHref(baseDir, cover.Href)
+
+	// The cover page is usually first in the spine or named after it
+	var pages []Item
+	byID := make(map[string]Item)
+	for _, item := range pkg.Manifest.Items {
+		byID[item.ID] = item
+	}
+	for _, itemRef := range pkg.Spine.ItemRefs {
+		if len(pages) == coverPages {
+			break
+		}
+		if item, ok := byID[itemRef.IDRef]; ok {
+			pages = append(pages, item)
+		}
+	}
+	for _, item := range pkg.Manifest.Items {
+		if strings.Contains(strings.ToLower(item.ID+" "+item.Href), "cover") {
+			pages = append(pages, item)
+		}
+	}
+
+	for _, page := range pages {
+		if !strings.Contains(page.MediaType, "html") && !isHTMLHref(page.Href) {
+			continue
+		}
+		pagePath := resolveHref(baseDir, page.Href)
+		if text := coverImageText(arc, pagePath, coverPath, opts); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// coverImageText returns the alt text or title of the image in the page
+// at pagePath that shows the image at coverPath
+func coverImageText(arc archive, pagePath, coverPath string, opts Options) string {
+	pageFile := findFile(arc, pagePath)
+	if pageFile == nil {
+		return ""
+	}
+	reader, err := pageFile.Open()
+	if err != nil {
+		return ""
+	}
+	defer reader.Close()
+	doc, err := parseHTML(reader, opts)
+	if err != nil {
+		return ""
+	}
+
+	var text string
+	var find func(n *html.Node)
+	find = func(n *html.Node) {
+		if text != "" {
+			return
+		}
+		if n.Type == html.ElementNode && (n.Data == "img" || n.Data == "image") {
+			// SVG images give their source in xlink:href
+			src := attr(n, "src")
+			if src == "" {
+				src = attr(n, "href")
+			}
+			if src != "" && zipPath(resolveHref(path.Dir(pagePath), src)) == zipPath(coverPath) {
+				text = strings.TrimSpace(attr(n, "alt"))
+				if text == "" {
+					text = strings.TrimSpace(attr(n, "title"))
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+	return text
+}
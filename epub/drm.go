@@ -0,0 +1,77 @@
+package epub
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// Encryption models META-INF/encryption.xml
+type Encryption struct {
+	XMLName       xml.Name        `xml:"encryption"`
+	EncryptedData []EncryptedData `xml:"EncryptedData"`
+}
+
+type EncryptedData struct {
+	EncryptionMethod EncryptionMethod `xml:"EncryptionMethod"`
+	CipherReference  CipherReference  `xml:"CipherData>CipherReference"`
+}
+
+type EncryptionMethod struct {
+	Algorithm string `xml:"Algorithm,attr"`
+}
+
+type CipherReference struct {
+	URI string `xml:"URI,attr"`
+}
+
+// fontObfuscation lists the algorithms used to obfuscate embedded fonts,
+// which leave the text content readable
+var fontObfuscation = map[string]bool{
+	"http://www.idpf.org/2008/embedding": true,
+	"http://ns.adobe.com/pdf/enc#RC":     true,
+}
+
+// checkDRM returns ErrDRM when the EPUB's content is encrypted. Books
+// whose encryption.xml only covers obfuscated fonts are still readable.
+// An encryption.xml that cannot be read or parsed says nothing either
+// way, so its error is returned instead.
+func checkDRM(arc archive, opts Options) error {
+	encryptionFile := findFile(arc, "META-INF/encryption.xml")
+	if encryptionFile == nil {
+		return nil
+	}
+
+	encryption, err := parseEncryption(encryptionFile, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, data := range encryption.EncryptedData {
+		if !fontObfuscation[data.EncryptionMethod.Algorithm] {
+			return ErrDRM
+		}
+	}
+	return nil
+}
+
+func parseEncryption(encryptionFile *archiveFile, opts Options) (*Encryption, error) {
+	reader, err := encryptionFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encryption.xml: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption.xml: %w", err)
+	}
+
+	var encryption Encryption
+	err = unmarshalXML(data, &encryption, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse encryption.xml: %w", err)
+	}
+
+	return &encryption, nil
+}
@@ -0,0 +1,106 @@
+package epub
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+)
+
+// encryptionXML returns an encryption.xml covering uri with algorithm
+func encryptionXML(algorithm, uri string) string {
+	return `<?xml version="1.0"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">
+  <enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="` + algorithm + `"/>
+    <enc:CipherData><enc:CipherReference URI="` + uri + `"/></enc:CipherData>
+  </enc:EncryptedData>
+</encryption>`
+}
+
+func TestConvertDRM(t *testing.T) {
+	chapter := map[string]string{"ch1.xhtml": xhtml("<p>Readable text</p>")}
+	with := func(encryption string) map[string]string {
+		files := maps.Clone(chapter)
+		files["META-INF/encryption.xml"] = encryption
+		return files
+	}
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr bool
+	}{
+		{"no encryption", chapter, false},
+		{"encrypted content", with(encryptionXML("http://www.w3.org/2001/04/xmlenc#aes128-cbc", "ch1.xhtml")), true},
+		{"idpf font obfuscation", with(encryptionXML("http://www.idpf.org/2008/embedding", "fonts/a.otf")), false},
+		{"adobe font obfuscation", with(encryptionXML("http://ns.adobe.com/pdf/enc#RC", "fonts/a.otf")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := convertBytes(t, testEPUB(t, tt.files, "ch1.xhtml"), Options{})
+			if tt.wantErr {
+				if !errors.Is(err, ErrDRM) {
+					t.Fatalf("got error %v, want ErrDRM", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(text, "Readable text") {
+				t.Errorf("text %q is missing the chapter", text)
+			}
+		})
+	}
+}
+
+func TestConvertDRMFailsEarly(t *testing.T) {
+	// Without a container.xml the book would otherwise fail later, with
+	// ErrNoContainer
+	data := zipFiles(t, map[string]string{
+		"mimetype":                "application/epub+zip",
+		"META-INF/encryption.xml": encryptionXML("http://www.w3.org/2001/04/xmlenc#aes128-cbc", "ch1.xhtml"),
+	})
+	_, err := convertBytes(t, data, Options{})
+	if !errors.Is(err, ErrDRM) {
+		t.Fatalf("got error %v, want ErrDRM", err)
+	}
+	if want := "DRM-protected"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not mention %q", err, want)
+	}
+}
+
+func TestConvertUnreadableEncryption(t *testing.T) {
+	files := map[string]string{"ch1.xhtml": xhtml("<p>Readable text</p>")}
+	big := encryptionXML("http://www.idpf.org/2008/embedding", "fonts/"+strings.Repeat("a", 4096)+".otf")
+
+	tests := []struct {
+		name       string
+		encryption string
+		opts       Options
+		want       error // wrapped in the error returned, or nil
+	}{
+		{"malformed", "<encryption", Options{}, nil},
+		{"too large", big, Options{MaxUncompressedBytes: 1024}, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withEncryption := maps.Clone(files)
+			withEncryption["META-INF/encryption.xml"] = tt.encryption
+			_, err := convertBytes(t, testEPUB(t, withEncryption, "ch1.xhtml"), tt.opts)
+			if err == nil {
+				t.Fatal("conversion succeeded")
+			}
+			if errors.Is(err, ErrDRM) {
+				t.Errorf("error %v reports DRM", err)
+			}
+			if !strings.Contains(err.Error(), "encryption.xml") {
+				t.Errorf("error %q does not name encryption.xml", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
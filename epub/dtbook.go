@@ -0,0 +1,51 @@
+package epub
+
+import (
+	"maps"
+	"strings"
+)
+
+// dtbookMediaType is the media type of DAISY DTBook content documents,
+// which some accessibility-focused books use in place of XHTML
+const dtbookMediaType = "application/x-dtbook+xml"
+
+// dtbookBlockElements are the DTBook elements set on lines of their own,
+// in addition to those it shares with HTML such as p and h1
+var dtbookBlockElements = map[string]bool{
+	"frontmatter": true, "bodymatter": true, "rearmatter": true,
+	"level": true, "level1": true, "level2": true, "level3": true,
+	"level4": true, "level5": true, "level6": true,
+	"doctitle": true, "docauthor": true, "hd": true, "bridgehead": true,
+	"list": true, "note": true, "annotation": true, "sidebar": true,
+	"prodnote": true, "imggroup": true, "epigraph": true, "byline": true,
+	"dateline": true, "author": true, "poem": true, "linegroup": true,
+	"line": true,
+}
+
+// isContentType reports whether a manifest item of the given media type
+// holds text to extract: HTML, DTBook, or one of opts.ContentTypes
+func isContentType(mediaType string, opts Options) bool {
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if strings.Contains(mediaType, "html") || mediaType == dtbookMediaType {
+		return true
+	}
+	for _, contentType := range opts.ContentTypes {
+		if strings.EqualFold(strings.TrimSpace(contentType), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// withDTBookBlocks returns opts with the DTBook block elements added to
+// its block elements, for extracting a DTBook document
+func withDTBookBlocks(opts Options) Options {
+	blocks := opts.BlockElements
+	if blocks == nil {
+		blocks = defaultBlockElements
+	}
+	blocks = maps.Clone(blocks)
+	maps.Copy(blocks, dtbookBlockElements)
+	opts.BlockElements = blocks
+	return opts
+}
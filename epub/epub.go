@@ -0,0 +1,1085 @@
+// Package epub extracts the plain text content from EPUB files.
+package epub
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Options controls how an EPUB is converted to text.
+type Options struct {
+	// Metadata prepends a header with the book's title, author, and language.
+	Metadata bool
+
+	// Separator is written on its own line between chapters (spine items).
+	// When empty, chapters are separated by a blank line only.
+	Separator string
+
+	// TOC prepends the table of contents, read from the EPUB3 navigation
+	// document or the EPUB2 NCX file.
+	TOC bool
+
+	// ChapterTitles writes each chapter's table of contents title above
+	// its text when one matches the chapter's href.
+	ChapterTitles bool
+
+	// NumberChapters writes "Chapter N" above each chapter's text, N being
+	// the position of its file in the spine, followed by ": " and its
+	// title when ChapterTitles is set and the chapter has one. Chapters
+	// split from one file by SplitAnchors share its number, and without a
+	// spine, as with Force, chapters are numbered by Index instead.
+	NumberChapters bool
+
+	// AltText writes the alt text of images as "[Image: ...]".
+	AltText bool
+
+	// CaptionPrefix is written before the caption of each figure, such as
+	// "Figure: ". Captions are always set on a line of their own.
+	CaptionPrefix string
+
+	// PageMarkers writes "[Page N]" where epub:type="pagebreak" elements
+	// mark the print edition's page boundaries.
+	PageMarkers bool
+
+	// IncludeNonLinear keeps spine items marked linear="no", which are
+	// skipped by default.
+	IncludeNonLinear bool
+
+	// OnlyBodymatter leaves out elements, usually a document's <body> or
+	// top-level <section>s, that epub:type marks as frontmatter or
+	// backmatter.
+	OnlyBodymatter bool
+
+	// SplitAnchors divides a content file into several chapters where the
+	// table of contents points to anchors within it, for books whose text
+	// is all in one file.
+	SplitAnchors bool
+
+	// Dedup extracts each content file only once, at its first spine
+	// position. By default a file the spine lists several times, such as a
+	// repeated interstitial, is extracted at every position.
+	Dedup bool
+
+	// Jobs is the number of chapters extracted concurrently; values below
+	// 1 extract sequentially.
+	Jobs int
+
+	// Rendition chooses the package document by 1-based position when
+	// container.xml lists several; 0 uses the first.
+	Rendition int
+
+	// Force extracts every HTML file in filename order when the package
+	// document is missing or cannot be parsed, instead of failing.
+	Force bool
+
+	// Chapter selects a single chapter to extract by its 1-based position
+	// in the spine; 0 extracts every chapter.
+	Chapter int
+
+	// ChapterHref selects a single chapter to extract by its manifest href.
+	ChapterHref string
+
+	// From and To limit extraction to the spine items between these
+	// 1-based positions, inclusive; 0 leaves that end of the range open.
+	// Chapter counts from the start of the range.
+	From int
+	To   int
+
+	// ContentTypes lists media types, beyond HTML and DTBook, of the
+	// manifest items whose text is extracted.
+	ContentTypes []string
+
+	// HeadWords and HeadChars, when positive, stop the extraction once
+	// this many words or characters of chapter text have been extracted,
+	// cutting the last chapter short; the remaining spine items are never
+	// read. HeadWords takes precedence when both are set.
+	HeadWords int
+	HeadChars int
+
+	// StartAt skips the spine items before the page the EPUB 2 guide
+	// gives for this reference type, such as "text" to leave out the
+	// cover and other front matter. Chapter counts from that page. A book
+	// without the reference is extracted in full, with a warning.
+	StartAt string
+
+	// OnChapter, when set, is called after each chapter is written by
+	// ConvertTo and ConvertFileTo, in reading order.
+	OnChapter func(Chapter)
+
+	// Format selects the output format: FormatText (the default when
+	// empty), FormatJSON, FormatMarkdown, or FormatAnnotated.
+	Format string
+
+	// Links keeps the targets of external hyperlinks: LinksInline writes
+	// each URL in brackets after the link text, and LinksFootnotes numbers
+	// the links and lists their URLs at the end of the chapter. Empty
+	// drops them. Links within the book are always dropped.
+	Links string
+
+	// MaxUncompressedBytes, when positive, aborts the conversion with
+	// ErrTooLarge once any one file, or all the files read together,
+	// decompress to more than this many bytes.
+	MaxUncompressedBytes int64
+
+	// Logger, when set, receives a line for each stage of the conversion:
+	// the package document found, the spine items to extract, and the
+	// size of each extracted chapter.
+	Logger *log.Logger
+
+	// Warnings receives a line for each problem that does not stop the
+	// conversion, such as a missing content file; nil means os.Stderr.
+	Warnings io.Writer
+
+	// RubyText keeps ruby annotations such as furigana, written in
+	// parentheses after the text they annotate. They are dropped by
+	// default.
+	RubyText bool
+
+	// IncludeHidden keeps the content of elements with the hidden
+	// attribute or aria-hidden="true", which is skipped by default.
+	IncludeHidden bool
+
+	// StripFootnoteRefs drops footnote reference markers: links marked
+	// epub:type="noteref", and superscript links such as <sup><a>12</a></sup>.
+	StripFootnoteRefs bool
+
+	// AnchorMarkers writes "[#id]" at the start of each heading and
+	// section that has an id, so that table of contents hrefs with
+	// fragments can be matched to places in the text.
+	AnchorMarkers bool
+
+	// SVGText keeps the labels of inline SVG drawings, each <text>
+	// element on a line of its own. Drawings are skipped by default.
+	SVGText bool
+
+	// FrontMatter starts Markdown output with a YAML front matter block
+	// of the title, author, language, and identifier, for static site
+	// generators. It has no effect on other formats.
+	FrontMatter bool
+
+	// Summary starts text and Markdown output with the title, author, and
+	// a numbered list of the chapters with their word counts. The whole
+	// book is extracted before any of it is written.
+	Summary bool
+
+	// Cleanup controls how the whitespace of the extracted text is tidied;
+	// nil means the settings returned by DefaultCleanup.
+	Cleanup *Cleanup
+
+	// KeepIndent keeps the non-breaking spaces some books use to indent
+	// the start of a line. They are dropped by default; non-breaking
+	// spaces within a line are always kept.
+	KeepIndent bool
+
+	// BlockElements names the elements whose content is set on lines of
+	// its own, such as "p" and "section"; nil means the set returned by
+	// DefaultBlockElements. Lists, line breaks, quotes, and preformatted
+	// text are always laid out on their own lines.
+	BlockElements map[string]bool
+
+	// DumpHTML, when set, is a directory into which the raw content file
+	// of each extracted chapter is copied, at its path within the archive.
+	DumpHTML string
+
+	// Transformers are applied in order to the text of each chapter once
+	// it has been extracted and cleaned up, before it is written.
+	Transformers []Transformer
+
+	// Wrap hard-wraps text and Markdown output at this many columns,
+	// breaking between words; 0 leaves lines unwrapped. Preformatted
+	// text and table rows are never wrapped.
+	Wrap int
+}
+
+// Convert reads an entire EPUB from r and returns its text content.
+// The archive is buffered in memory because ZIP requires random access.
+func Convert(r io.Reader, opts Options) (string, error) {
+	var text strings.Builder
+	if err := ConvertTo(&text, r, opts); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// ConvertFile opens the EPUB at path and returns its text content.
+func ConvertFile(path string, opts Options) (string, error) {
+	var text strings.Builder
+	if err := ConvertFileTo(&text, path, opts); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// ConvertTo reads an entire EPUB from r and writes its text content to w
+// one chapter at a time. The archive is buffered in memory because ZIP
+// requires random access, but the extracted text is not.
+func ConvertTo(w io.Writer, r io.Reader, opts Options) error {
+	arc, err := readZip(r)
+	if err != nil {
+		return err
+	}
+	return writeBook(w, arc, opts)
+}
+
+// ConvertFileTo opens the EPUB at path and writes its text content to w
+// one chapter at a time, so memory use stays bounded for large books.
+func ConvertFileTo(w io.Writer, path string, opts Options) error {
+	arc, closeArc, err := openPath(path)
+	if err != nil {
+		return err
+	}
+	defer closeArc()
+
+	return writeBook(w, arc, opts)
+}
+
+// Read reads an entire EPUB from r and extracts its chapters.
+// The archive is buffered in memory because ZIP requires random access.
+func Read(r io.Reader, opts Options) (*Book, error) {
+	arc, err := readZip(r)
+	if err != nil {
+		return nil, err
+	}
+	return readBook(arc, opts)
+}
+
+// ReadFile opens the EPUB at path and extracts its chapters.
+func ReadFile(path string, opts Options) (*Book, error) {
+	arc, closeArc, err := openPath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArc()
+
+	return readBook(arc, opts)
+}
+
+// ConvertWithVisitor reads the EPUB in r, which holds size bytes, and
+// calls fn with each chapter in reading order as soon as it has been
+// extracted, so the whole book is never held in memory. Extraction stops
+// at the first error fn returns, which ConvertWithVisitor then returns.
+func ConvertWithVisitor(r io.ReaderAt, size int64, opts Options, fn func(Chapter) error) error {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		if mobiErr := checkMOBI(r); mobiErr != nil {
+			err = mobiErr
+		}
+		return fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	return visitBook(newZipArchive(reader), opts, fn)
+}
+
+// ConvertFileWithVisitor opens the EPUB at path and calls fn with each
+// chapter in reading order as it is extracted, like ConvertWithVisitor.
+func ConvertFileWithVisitor(path string, opts Options, fn func(Chapter) error) error {
+	arc, closeArc, err := openPath(path)
+	if err != nil {
+		return err
+	}
+	defer closeArc()
+
+	return visitBook(arc, opts, fn)
+}
+
+// SpineEntry describes one chapter that would be extracted
+type SpineEntry struct {
+	Index int    // 1-based position, as used by Options.Chapter
+	IDRef string // manifest id
+	Path  string // path of the content file within the archive
+	Title string // table of contents title, if any
+}
+
+// List reads an entire EPUB from r and returns its chapters in reading
+// order without extracting them.
+func List(r io.Reader, opts Options) ([]SpineEntry, error) {
+	arc, err := readZip(r)
+	if err != nil {
+		return nil, err
+	}
+	return listSpine(arc, opts)
+}
+
+// ListFile opens the EPUB at path and returns its chapters in reading
+// order without extracting them.
+func ListFile(path string, opts Options) ([]SpineEntry, error) {
+	arc, closeArc, err := openPath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArc()
+
+	return listSpine(arc, opts)
+}
+
+// Renditions reads an entire EPUB from r and returns the package
+// documents its container.xml lists, in the order Options.Rendition
+// numbers them.
+func Renditions(r io.Reader, opts Options) ([]RootFile, error) {
+	arc, err := readZip(r)
+	if err != nil {
+		return nil, err
+	}
+	return listRenditions(arc, opts)
+}
+
+// RenditionsFile opens the EPUB at path and returns the package
+// documents its container.xml lists, in the order Options.Rendition
+// numbers them.
+func RenditionsFile(path string, opts Options) ([]RootFile, error) {
+	arc, closeArc, err := openPath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArc()
+
+	return listRenditions(arc, opts)
+}
+
+func listRenditions(arc archive, opts Options) ([]RootFile, error) {
+	arc = limitArchive(arc, opts.MaxUncompressedBytes)
+	containerFile := findFile(arc, "META-INF/container.xml")
+	if containerFile == nil {
+		return nil, ErrNoContainer
+	}
+	container, err := parseContainer(containerFile, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(container.RootFiles.RootFile) == 0 {
+		return nil, ErrNoRootfile
+	}
+	return renditions(container.RootFiles.RootFile), nil
+}
+
+// ListManifest reads an entire EPUB from r and returns every item of its
+// manifest, including images, fonts, and stylesheets that are never
+// extracted.
+func ListManifest(r io.Reader, opts Options) ([]Item, error) {
+	arc, err := readZip(r)
+	if err != nil {
+		return nil, err
+	}
+	return listManifest(arc, opts)
+}
+
+// ListManifestFile opens the EPUB at path and returns every item of its
+// manifest.
+func ListManifestFile(path string, opts Options) ([]Item, error) {
+	arc, closeArc, err := openPath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArc()
+
+	return listManifest(arc, opts)
+}
+
+func listManifest(arc archive, opts Options) ([]Item, error) {
+	pkg, _, err := loadPackage(limitArchive(arc, opts.MaxUncompressedBytes), opts)
+	if err != nil {
+		return nil, err
+	}
+	return pkg.Manifest.Items, nil
+}
+
+func listSpine(arc archive, opts Options) ([]SpineEntry, error) {
+	// Titles come from the table of contents
+	opts.ChapterTitles = true
+	src, err := openSource(arc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]SpineEntry, len(src.items))
+	for i, item := range src.items {
+		entries[i] = SpineEntry{
+			Index: i + 1,
+			IDRef: item.ID,
+			Path:  resolveHref(src.baseDir, item.Href),
+			Title: tocTitle(src.book.TOC, item.Href),
+		}
+	}
+	return entries, nil
+}
+
+// visitBook calls fn with each chapter of the EPUB in reading order
+func visitBook(arc archive, opts Options, fn func(Chapter) error) error {
+	src, err := openSource(arc, opts)
+	if err != nil {
+		return err
+	}
+	return src.eachChapter(opts, fn)
+}
+
+// readBook extracts every chapter of the EPUB into memory
+func readBook(arc archive, opts Options) (*Book, error) {
+	src, err := openSource(arc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	book := src.book
+	err = src.eachChapter(opts, func(chapter Chapter) error {
+		book.Chapters = append(book.Chapters, chapter)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// writeBook streams the EPUB's chapters to w in the requested format
+func writeBook(w io.Writer, arc archive, opts Options) error {
+	bw, err := newBookWriter(w, opts)
+	if err != nil {
+		return err
+	}
+
+	src, err := openSource(arc, opts)
+	if err != nil {
+		return err
+	}
+
+	writeChapter := func(chapter Chapter) error {
+		if err := bw.chapter(chapter); err != nil {
+			return err
+		}
+		if opts.OnChapter != nil {
+			opts.OnChapter(chapter)
+		}
+		return nil
+	}
+
+	// The summary counts the words of every chapter, so they must all be
+	// extracted before anything is written
+	if opts.Summary {
+		err = src.eachChapter(opts, func(chapter Chapter) error {
+			src.book.Chapters = append(src.book.Chapters, chapter)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := bw.begin(src.book); err != nil {
+			return err
+		}
+		for _, chapter := range src.book.Chapters {
+			if err := writeChapter(chapter); err != nil {
+				return err
+			}
+		}
+		return bw.end()
+	}
+
+	if err := bw.begin(src.book); err != nil {
+		return err
+	}
+	if err := src.eachChapter(opts, writeChapter); err != nil {
+		return err
+	}
+	return bw.end()
+}
+
+// source is an opened EPUB whose package document has been parsed but
+// whose chapters have not yet been extracted
+type source struct {
+	arc     archive
+	baseDir string
+	items   []spineItem
+	book    *Book // metadata and table of contents, without chapters
+}
+
+// spineItem is a manifest item at one place in the spine. A content file
+// the spine lists several times has an entry for each.
+type spineItem struct {
+	Item
+	position int // 1-based spine position, or 0 for items found without a spine
+}
+
+// openSource locates and parses the package document and resolves the
+// spine into the content files to extract
+func openSource(arc archive, opts Options) (*source, error) {
+	switch opts.Links {
+	case "", LinksInline, LinksFootnotes:
+	default:
+		return nil, fmt.Errorf("unknown link mode: %s", opts.Links)
+	}
+
+	arc = limitArchive(arc, opts.MaxUncompressedBytes)
+
+	// Encrypted content would only produce gibberish, so fail early
+	if err := checkDRM(arc, opts); err != nil {
+		return nil, err
+	}
+
+	pkg, opfPath, err := loadPackage(arc, opts)
+	if err != nil {
+		if !opts.Force {
+			return nil, err
+		}
+		opts.warnf("%v; extracting HTML files in name order", err)
+		return forcedSource(arc, opts)
+	}
+
+	// Create a base directory for resolving relative paths
+	baseDir := path.Dir(zipPath(opfPath))
+
+	// Create a map of ID to manifest item
+	idToItem := make(map[string]Item)
+	foldedIDs := make(map[string]Item)   // HTML items by lowercased ID
+	manifestIDs := make(map[string]bool) // every lowercased manifest ID
+	guessed := make(map[string]bool)
+	for _, item := range pkg.Manifest.Items {
+		manifestIDs[strings.ToLower(item.ID)] = true
+		// Only include HTML content, DTBook, and the types asked for
+		if isContentType(item.MediaType, opts) {
+			idToItem[item.ID] = item
+		} else if isHTMLHref(item.Href) {
+			// Some books omit or mistype the media type of real chapters
+			idToItem[item.ID] = item
+			guessed[item.ID] = true
+		}
+	}
+	for _, item := range pkg.Manifest.Items {
+		folded := strings.ToLower(item.ID)
+		if _, ok := foldedIDs[folded]; !ok && idToItem[item.ID] == item {
+			foldedIDs[folded] = item
+		}
+	}
+
+	// Get ordered content files
+	var spineItems []spineItem
+	for i, itemRef := range pkg.Spine.ItemRefs {
+		// Skip auxiliary content such as popup footnotes unless requested
+		if !itemRef.IsLinear() && !opts.IncludeNonLinear {
+			continue
+		}
+		item, ok := idToItem[itemRef.IDRef]
+		if !ok {
+			// Some books differ in case between the spine and manifest
+			item, ok = foldedIDs[strings.ToLower(itemRef.IDRef)]
+		}
+		if !ok {
+			if !manifestIDs[strings.ToLower(itemRef.IDRef)] {
+				opts.warnf("spine item %d (idref=%s) does not match any manifest item", i+1, itemRef.IDRef)
+			}
+			continue
+		}
+		if guessed[item.ID] {
+			opts.warnf("spine item %d (idref=%s) treated as HTML based on its extension (media-type %q): %s", i+1, itemRef.IDRef, item.MediaType, item.Href)
+		}
+		spineItems = append(spineItems, spineItem{Item: item, position: i + 1})
+	}
+
+	// Content files listed at several spine positions are extracted each
+	// time unless duplicates were asked to be skipped
+	if opts.Dedup {
+		spineItems = dedupItems(spineItems, baseDir)
+	}
+	opts.logf("spine lists %d items, %d to extract", len(pkg.Spine.ItemRefs), len(spineItems))
+
+	// Begin at a landmark of the guide if one was asked for
+	if opts.StartAt != "" {
+		spineItems = startAt(spineItems, pkg.Guide, opts)
+	}
+
+	// Limit extraction to a range or a single chapter if one was selected
+	spineItems, err = selectChapter(spineItems, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	book := &Book{Metadata: pkg.Metadata}
+	book.Metadata.Direction = pkg.Spine.PageProgressionDirection
+	book.Metadata.UniqueID = pkg.UniqueIdentifier
+	book.Guide = pkg.Guide.References
+	if opts.Metadata {
+		book.Metadata.Cover = readCoverText(arc, pkg, baseDir, opts)
+	}
+
+	// Read the table of contents
+	if opts.TOC || opts.ChapterTitles || opts.Summary || opts.SplitAnchors {
+		book.TOC, err = readTOC(arc, pkg, baseDir, opts)
+		if err != nil {
+			opts.warnf("%v", err)
+		}
+		opts.logf("table of contents has %d entries", len(book.TOC))
+	}
+	if !opts.SplitAnchors {
+		warnSingleFile(arc, pkg, spineItems, book.TOC, baseDir, opts)
+	}
+
+	return &source{
+		arc:     arc,
+		baseDir: baseDir,
+		items:   spineItems,
+		book:    book,
+	}, nil
+}
+
+// Transformer rewrites the text of a chapter, for example to redact or
+// replace words
+type Transformer func(string) string
+
+// Cleanup is the whitespace handling applied to each chapter's text
+type Cleanup struct {
+	// CollapseSpaces replaces each run of spaces and tabs with a single
+	// space.
+	CollapseSpaces bool
+
+	// TrimLines removes the whitespace from the ends of each line.
+	TrimLines bool
+
+	// MaxBlankLines is the most blank lines kept in a row; negative
+	// values keep them all. Blank lines within preformatted text are
+	// always kept, and 0 also leaves out the blank lines between
+	// chapters and the other parts of text output.
+	MaxBlankLines int
+
+	// KeepNewlines keeps the line breaks within the source text, which
+	// are otherwise treated as spaces.
+	KeepNewlines bool
+}
+
+// DefaultCleanup returns the whitespace handling used when
+// Options.Cleanup is nil: spaces are collapsed, lines trimmed, and blank
+// lines squeezed to one.
+func DefaultCleanup() Cleanup {
+	return Cleanup{CollapseSpaces: true, TrimLines: true, MaxBlankLines: 1}
+}
+
+// wraps reports whether chapter text is hard-wrapped, which only text
+// and Markdown output are
+func (opts Options) wraps() bool {
+	return opts.Wrap > 0 && opts.Format != FormatJSON && opts.Format != FormatAnnotated
+}
+
+// cleanup returns the whitespace handling to apply
+func (opts Options) cleanup() Cleanup {
+	if opts.Cleanup != nil {
+		return *opts.Cleanup
+	}
+	return DefaultCleanup()
+}
+
+// logf writes a progress line to opts.Logger, if one is set
+func (opts Options) logf(format string, args ...any) {
+	if opts.Logger != nil {
+		opts.Logger.Printf(format, args...)
+	}
+}
+
+// warnf writes a warning to opts.Warnings
+func (opts Options) warnf(format string, args ...any) {
+	w := opts.Warnings
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "Warning: "+format+"\n", args...)
+}
+
+// packageMediaType is the media type of OPF package documents
+const packageMediaType = "application/oebps-package+xml"
+
+// renditions returns the rootfiles that Options.Rendition chooses among:
+// those with the OPF media type, or all of them if none has it
+func renditions(rootFiles []RootFile) []RootFile {
+	var candidates []RootFile
+	for _, rootFile := range rootFiles {
+		if rootFile.MediaType == packageMediaType {
+			candidates = append(candidates, rootFile)
+		}
+	}
+	if len(candidates) == 0 {
+		return rootFiles
+	}
+	return candidates
+}
+
+// selectRootFile picks the package document to read. Rootfiles with the
+// OPF media type are preferred; opts.Rendition chooses among them by 1-based
+// position, with 0 meaning the first.
+func selectRootFile(rootFiles []RootFile, opts Options) (RootFile, error) {
+	rendition := opts.Rendition
+	if len(rootFiles) == 0 {
+		return RootFile{}, ErrNoRootfile
+	}
+	candidates := renditions(rootFiles)
+
+	if rendition == 0 {
+		if len(rootFiles) > 1 {
+			opts.warnf("container.xml lists %d rootfiles; using %s (list them with -renditions and choose another with -rendition)", len(rootFiles), candidates[0].FullPath)
+		}
+		return candidates[0], nil
+	}
+
+	if rendition < 1 || rendition > len(candidates) {
+		return RootFile{}, fmt.Errorf("rendition %d out of range (container.xml lists %d package documents)", rendition, len(candidates))
+	}
+	return candidates[rendition-1], nil
+}
+
+// dedupItems drops the items whose content file appeared earlier in items
+func dedupItems(items []spineItem, baseDir string) []spineItem {
+	seen := make(map[string]bool)
+	var unique []spineItem
+	for _, item := range items {
+		contentPath := zipPath(resolveHref(baseDir, item.Href))
+		if seen[contentPath] {
+			continue
+		}
+		seen[contentPath] = true
+		unique = append(unique, item)
+	}
+	return unique
+}
+
+// startAt drops the items before the one the guide's reference of the
+// given type points to, keeping them all if there is no such reference
+func startAt(items []spineItem, guide Guide, opts Options) []spineItem {
+	refType := opts.StartAt
+	ref, ok := guide.Reference(refType)
+	if !ok {
+		opts.warnf("guide has no %q reference; extracting from the start", refType)
+		return items
+	}
+
+	target := path.Clean(hrefPath(ref.Href))
+	for i, item := range items {
+		if path.Clean(hrefPath(item.Href)) == target {
+			return items[i:]
+		}
+	}
+	opts.warnf("guide %q reference %s is not in the spine; extracting from the start", refType, ref.Href)
+	return items
+}
+
+// selectChapter narrows the spine items to the range chosen by opts.From
+// and opts.To, then to the one chosen by opts.Chapter or
+// opts.ChapterHref, if any of them are set
+func selectChapter(items []spineItem, opts Options) ([]spineItem, error) {
+	if opts.From != 0 || opts.To != 0 {
+		from, to := opts.From, opts.To
+		if from == 0 {
+			from = 1
+		}
+		if to == 0 {
+			to = len(items)
+		}
+		switch {
+		case from < 1 || from > len(items):
+			return nil, fmt.Errorf("chapter %d out of range (book has %d chapters)", from, len(items))
+		case to < 1 || to > len(items):
+			return nil, fmt.Errorf("chapter %d out of range (book has %d chapters)", to, len(items))
+		case from > to:
+			return nil, fmt.Errorf("invalid chapter range %d to %d", from, to)
+		}
+		items = items[from-1 : to]
+	}
+
+	if opts.Chapter != 0 {
+		if opts.Chapter < 1 || opts.Chapter > len(items) {
+			return nil, fmt.Errorf("chapter %d out of range (book has %d chapters)", opts.Chapter, len(items))
+		}
+		items = items[opts.Chapter-1 : opts.Chapter]
+	}
+
+	if opts.ChapterHref != "" {
+		target := path.Clean(hrefPath(opts.ChapterHref))
+		for _, item := range items {
+			if path.Clean(hrefPath(item.Href)) == target {
+				return []spineItem{item}, nil
+			}
+		}
+		return nil, fmt.Errorf("no chapter found with href: %s", opts.ChapterHref)
+	}
+
+	return items, nil
+}
+
+// loadPackage finds the package document through container.xml and
+// parses it, returning it along with its path in the archive
+func loadPackage(arc archive, opts Options) (*Package, string, error) {
+	// Find and parse the container.xml file to get the OPF file
+	containerFile := findFile(arc, "META-INF/container.xml")
+	if containerFile == nil {
+		return nil, "", ErrNoContainer
+	}
+
+	// Parse container.xml to find the OPF file
+	container, err := parseContainer(containerFile, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	opts.logf("container.xml lists %d rootfiles", len(container.RootFiles.RootFile))
+
+	// Get the OPF file path
+	rootFile, err := selectRootFile(container.RootFiles.RootFile, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	opfPath := rootFile.FullPath
+
+	// Find the OPF file
+	opfFile := findFile(arc, opfPath)
+	if opfFile == nil {
+		return nil, "", fmt.Errorf("%w at path: %s", ErrOPFNotFound, opfPath)
+	}
+
+	// Parse the OPF file to get content ordering
+	pkg, err := parsePackage(opfFile, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	opts.logf("parsed package document %s: %d manifest items", opfPath, len(pkg.Manifest.Items))
+
+	return pkg, opfPath, nil
+}
+
+// forcedSource treats every HTML file in the archive as a chapter, in
+// filename order. It recovers text from books whose package document is
+// missing or unreadable.
+func forcedSource(arc archive, opts Options) (*source, error) {
+	var names []string
+	for _, fileName := range arc.names() {
+		if name := zipPath(fileName); isHTMLHref(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var items []spineItem
+	for _, name := range names {
+		// Hrefs are URL paths, so escape names that need it
+		href := (&url.URL{Path: name}).EscapedPath()
+		items = append(items, spineItem{Item: Item{ID: name, Href: href}})
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no HTML files found in EPUB")
+	}
+	opts.logf("found %d HTML files", len(items))
+
+	items, err := selectChapter(items, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &source{
+		arc:   arc,
+		items: items,
+		book:  &Book{},
+	}, nil
+}
+
+// chapterResult is the outcome of extracting one spine item
+type chapterResult struct {
+	text string
+	err  error
+}
+
+// eachChapter extracts the spine items concurrently and calls fn with
+// each chapter in reading order. At most opts.Jobs chapters are extracted
+// or waiting to be consumed at once, which bounds memory use.
+func (s *source) eachChapter(opts Options, fn func(Chapter) error) error {
+	results := make([]chan chapterResult, len(s.items))
+	for i := range results {
+		results[i] = make(chan chapterResult, 1)
+	}
+
+	// Start extractions as slots free up, stopping early if fn fails.
+	// Extractions already running are waited for before returning, so none
+	// outlive the call and the archive they read.
+	sem := make(chan struct{}, max(opts.Jobs, 1))
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, item := range s.items {
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				select {
+				case <-done:
+					return
+				default:
+				}
+				text, err := extractChapter(s.arc, s.baseDir, item.Item, s.anchors(item.Item, opts), opts)
+				results[i] <- chapterResult{text: text, err: err}
+			}()
+		}
+	}()
+
+	// Number the chapters as they are passed on, skipping empty ones and
+	// cutting the book short once the requested opening has been extracted
+	index := 0
+	head := newHeadLimit(opts)
+	emit := func(chapter Chapter) (bool, error) {
+		// Placeholder pages would only add stray separators
+		if strings.TrimSpace(chapter.Text) == "" {
+			opts.logf("skipped empty chapter %s", chapter.Href)
+			return false, nil
+		}
+		index++
+		chapter.Index = index
+		for _, transform := range opts.Transformers {
+			chapter.Text = transform(chapter.Text)
+		}
+		reached := false
+		if head != nil {
+			chapter.Text, reached = head.take(chapter.Text)
+		}
+		return reached, fn(chapter)
+	}
+
+	for i, item := range s.items {
+		result := <-results[i]
+		<-sem
+
+		if errors.Is(result.err, ErrTooLarge) {
+			return result.err
+		}
+		if result.err != nil {
+			opts.warnf("%s%v", s.describe(item), result.err)
+			continue
+		}
+		opts.logf("extracted %s: %d bytes", item.Href, len(result.text))
+
+		anchors := s.anchors(item.Item, opts)
+		if len(anchors) == 0 {
+			reached, err := emit(Chapter{
+				IDRef:    item.ID,
+				Href:     item.Href,
+				Title:    tocTitle(s.book.TOC, item.Href),
+				Text:     result.text,
+				position: item.position,
+			})
+			if err != nil || reached {
+				return err
+			}
+			continue
+		}
+
+		// Make a chapter of each part of the file the TOC points to
+		titles := make(map[string]string)
+		for _, anchor := range anchors {
+			titles[anchor.id] = anchor.title
+		}
+		for _, sec := range splitSections(result.text) {
+			chapter := Chapter{IDRef: item.ID, Href: item.Href, Text: sec.text, position: item.position}
+			if sec.id != "" {
+				chapter.Href += "#" + sec.id
+				chapter.Title = titles[sec.id]
+			}
+			if reached, err := emit(chapter); err != nil || reached {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// describe identifies item by its spine position and idref for
+// warnings, or returns "" for items found without a spine
+func (s *source) describe(item spineItem) string {
+	if item.position == 0 {
+		return ""
+	}
+	return fmt.Sprintf("spine item %d (idref=%s) ", item.position, item.ID)
+}
+
+// anchors returns the TOC anchors at which to split item into chapters,
+// or nil when the item is extracted whole
+func (s *source) anchors(item Item, opts Options) []tocAnchor {
+	if !opts.SplitAnchors {
+		return nil
+	}
+	return anchorsFor(s.book.TOC, item.Href)
+}
+
+// extractChapter extracts the text of a single spine item. Each call
+// opens its own reader on the ZIP entry, so it is safe to run
+// concurrently.
+func extractChapter(arc archive, baseDir string, item Item, anchors []tocAnchor, opts Options) (string, error) {
+	contentPath := resolveHref(baseDir, item.Href)
+
+	// Find the file in the ZIP
+	contentFile := findFile(arc, contentPath)
+	if contentFile == nil {
+		return "", fmt.Errorf("content file not found: %s", contentPath)
+	}
+
+	// DTBook lays out its text with elements of its own
+	if strings.EqualFold(item.MediaType, dtbookMediaType) {
+		opts = withDTBookBlocks(opts)
+	}
+
+	// Keep a copy of the source for debugging
+	if opts.DumpHTML != "" {
+		if err := dumpFile(contentFile, opts.DumpHTML); err != nil {
+			opts.warnf("%v", err)
+		}
+	}
+
+	// Extract text from this content file
+	content, err := extractTextFromHTMLFile(contentFile, anchors, opts)
+	if err != nil {
+		return "", fmt.Errorf("error processing %s: %w", contentPath, err)
+	}
+	if opts.Format == FormatAnnotated {
+		content = annotateLines(content, item.Href)
+	}
+
+	return content, nil
+}
+
+// hrefPath converts an href to the file path it refers to, dropping any
+// fragment and decoding percent-escapes such as %20
+func hrefPath(href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if decoded, err := url.PathUnescape(href); err == nil {
+		return decoded
+	}
+	return href
+}
+
+// resolveHref returns the archive path of an href relative to baseDir.
+// Archive paths always use forward slashes, whatever the host OS.
+func resolveHref(baseDir, href string) string {
+	return path.Join(baseDir, hrefPath(href))
+}
+
+// zipPath normalizes an archive entry name, converting the backslashes
+// some Windows tools write into forward slashes. Entry names are relative
+// to the archive root, so a leading slash or "./" is dropped.
+func zipPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+}
+
+// isHTMLHref reports whether href names an HTML file by its extension
+func isHTMLHref(href string) bool {
+	switch strings.ToLower(path.Ext(href)) {
+	case ".html", ".xhtml", ".htm":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,336 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testContainer points at the package document of the books built by
+// testEPUB
+const testContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+// zipFiles returns a ZIP archive of files, stored with the mimetype entry
+// first as EPUB requires
+func zipFiles(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	names := slices.Sorted(maps.Keys(files))
+	if i := slices.Index(names, "mimetype"); i > 0 {
+		names = append([]string{"mimetype"}, slices.Delete(names, i, i+1)...)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testEPUB returns a minimal EPUB holding files, with the package document
+// at the root of the archive and a spine listing the given hrefs in order.
+// Each distinct href gets one manifest item.
+func testEPUB(t *testing.T, files map[string]string, spine ...string) []byte {
+	t.Helper()
+	var manifest, itemRefs strings.Builder
+	ids := make(map[string]string)
+	for _, href := range spine {
+		id, ok := ids[href]
+		if !ok {
+			id = fmt.Sprintf("item%d", len(ids)+1)
+			ids[href] = id
+			fmt.Fprintf(&manifest, "    <item id=%q href=%q media-type=\"application/xhtml+xml\"/>\n", id, href)
+		}
+		fmt.Fprintf(&itemRefs, "    <itemref idref=%q/>\n", id)
+	}
+
+	all := map[string]string{
+		"mimetype":               "application/epub+zip",
+		"META-INF/container.xml": testContainer,
+		"content.opf": `<?xml version="1.0"?>
+<package version="3.0" xmlns="http://www.idpf.org/2007/opf" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">test</dc:identifier>
+    <dc:title>Test</dc:title>
+  </metadata>
+  <manifest>
+` + manifest.String() + `  </manifest>
+  <spine>
+` + itemRefs.String() + `  </spine>
+</package>`,
+	}
+	maps.Copy(all, files)
+	return zipFiles(t, all)
+}
+
+// xhtml wraps body in an XHTML document
+func xhtml(body string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Test</title></head>
+<body>` + body + `</body></html>`
+}
+
+// convertBytes returns the text of an in-memory EPUB
+func convertBytes(t *testing.T, data []byte, opts Options) (string, error) {
+	t.Helper()
+	return Convert(bytes.NewReader(data), opts)
+}
+
+func TestHrefPath(t *testing.T) {
+	tests := []struct{ href, want string }{
+		{"chapter1.xhtml", "chapter1.xhtml"},
+		{"chapter%201.xhtml", "chapter 1.xhtml"},
+		{"text/chapter%201.xhtml#note", "text/chapter 1.xhtml"},
+		{"caf%C3%A9.xhtml", "café.xhtml"},
+		{"100%.xhtml", "100%.xhtml"},
+	}
+	for _, tt := range tests {
+		if got := hrefPath(tt.href); got != tt.want {
+			t.Errorf("hrefPath(%q) = %q, want %q", tt.href, got, tt.want)
+		}
+	}
+}
+
+func TestConvertEscapedHref(t *testing.T) {
+	data := testEPUB(t, map[string]string{
+		"chapter 1.xhtml": xhtml("<p>Text from a spaced filename</p>"),
+	}, "chapter%201.xhtml")
+	text, err := convertBytes(t, data, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "Text from a spaced filename") {
+		t.Errorf("text %q is missing the chapter", text)
+	}
+}
+
+func TestConvertRepeatedSpineItem(t *testing.T) {
+	data := testEPUB(t, map[string]string{
+		"ch1.xhtml":   xhtml("<p>Chapter one</p>"),
+		"break.xhtml": xhtml("<p>Interlude</p>"),
+		"ch2.xhtml":   xhtml("<p>Chapter two</p>"),
+	}, "ch1.xhtml", "break.xhtml", "ch2.xhtml", "break.xhtml")
+
+	tests := []struct {
+		name  string
+		dedup bool
+		want  int
+	}{
+		{"default extracts every occurrence", false, 2},
+		{"dedup extracts the first only", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book, err := Read(bytes.NewReader(data), Options{Dedup: tt.dedup})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got int
+			for _, chapter := range book.Chapters {
+				if chapter.Text == "Interlude" {
+					got++
+				}
+			}
+			if got != tt.want {
+				t.Errorf("interlude extracted %d times, want %d", got, tt.want)
+			}
+			if n := len(book.Chapters); n != 2+tt.want {
+				t.Errorf("got %d chapters, want %d", n, 2+tt.want)
+			}
+		})
+	}
+}
+
+// slowArchive delays opening chapters after the first and counts the
+// chapter readers still open
+type slowArchive struct {
+	archive
+	reading atomic.Int32
+}
+
+func (a *slowArchive) open(name string) (io.ReadCloser, error) {
+	if !strings.HasSuffix(name, ".xhtml") {
+		return a.archive.open(name)
+	}
+	a.reading.Add(1)
+	if name != "ch1.xhtml" {
+		time.Sleep(20 * time.Millisecond)
+	}
+	rc, err := a.archive.open(name)
+	if err != nil {
+		a.reading.Add(-1)
+		return nil, err
+	}
+	return closeFunc{rc, func() { a.reading.Add(-1) }}, nil
+}
+
+// closeFunc calls done once its reader is closed
+type closeFunc struct {
+	io.ReadCloser
+	done func()
+}
+
+func (c closeFunc) Close() error {
+	defer c.done()
+	return c.ReadCloser.Close()
+}
+
+func TestEachChapterWaitsForWorkers(t *testing.T) {
+	files := make(map[string]string)
+	var spine []string
+	for i := 1; i <= 8; i++ {
+		name := fmt.Sprintf("ch%d.xhtml", i)
+		files[name] = xhtml(fmt.Sprintf("<p>Chapter %d</p>", i))
+		spine = append(spine, name)
+	}
+	zipped, err := readZip(bytes.NewReader(testEPUB(t, files, spine...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	arc := &slowArchive{archive: zipped}
+	opts := Options{Jobs: 4}
+	s, err := openSource(arc, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = s.eachChapter(opts, func(Chapter) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("got error %v, want %v", err, stop)
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+	if n := arc.reading.Load(); n != 0 {
+		t.Errorf("%d chapters still being read after eachChapter returned", n)
+	}
+}
+
+func TestConvertNumberChapters(t *testing.T) {
+	data := testEPUB(t, map[string]string{
+		"ch1.xhtml":   xhtml("<p>One</p>"),
+		"blank.xhtml": xhtml(""),
+		"ch3.xhtml":   xhtml("<p>Three</p>"),
+	}, "ch1.xhtml", "blank.xhtml", "ch3.xhtml")
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"whole book", Options{NumberChapters: true}, []string{"Chapter 1\n\nOne", "Chapter 3\n\nThree"}},
+		{"selected chapter", Options{NumberChapters: true, Chapter: 3}, []string{"Chapter 3\n\nThree"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := convertBytes(t, data, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("text %q does not contain %q", text, want)
+				}
+			}
+			if strings.Contains(text, "Chapter 2") {
+				t.Errorf("text %q numbers a chapter by its output order", text)
+			}
+		})
+	}
+}
+
+func TestConvertNumberRepeatedChapters(t *testing.T) {
+	data := testEPUB(t, map[string]string{
+		"ch1.xhtml":   xhtml("<p>One</p>"),
+		"break.xhtml": xhtml("<p>Interlude</p>"),
+		"ch2.xhtml":   xhtml("<p>Two</p>"),
+	}, "ch1.xhtml", "break.xhtml", "ch2.xhtml", "break.xhtml")
+	text, err := convertBytes(t, data, Options{NumberChapters: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Chapter 1\n\nOne", "Chapter 2\n\nInterlude", "Chapter 3\n\nTwo", "Chapter 4\n\nInterlude"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text %q does not contain %q", text, want)
+		}
+	}
+}
+
+func TestConvertWarnsRepeatedSpinePosition(t *testing.T) {
+	// The manifest lists missing.xhtml, but the archive does not hold it
+	data := testEPUB(t, map[string]string{
+		"ch1.xhtml": xhtml("<p>One</p>"),
+		"ch2.xhtml": xhtml("<p>Two</p>"),
+	}, "ch1.xhtml", "missing.xhtml", "ch2.xhtml", "missing.xhtml")
+	var warnings bytes.Buffer
+	if _, err := convertBytes(t, data, Options{Warnings: &warnings}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"spine item 2 (idref=item2)", "spine item 4 (idref=item2)"} {
+		if !strings.Contains(warnings.String(), want) {
+			t.Errorf("warnings %q do not mention %q", warnings.String(), want)
+		}
+	}
+}
+
+func TestConvertFileMaxBlankLines(t *testing.T) {
+	data := testEPUB(t, map[string]string{
+		"ch1.xhtml": xhtml("<p>one</p><br/><br/><br/><br/><p>two</p><pre>a\n\n\nb</pre>"),
+		"ch2.xhtml": xhtml("<p>three</p>"),
+	}, "ch1.xhtml", "ch2.xhtml")
+	path := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(path, data, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		max  int
+		want string
+	}{
+		{1, "one\n\ntwo\n\na\n\n\nb\n\n----\n\nthree\n\n"},
+		{2, "one\n\n\ntwo\n\na\n\n\nb\n\n----\n\nthree\n\n"},
+		{0, "one\ntwo\na\n\n\nb\n----\nthree\n"},
+		{-1, "one\n\n\n\n\n\ntwo\n\na\n\n\nb\n\n----\n\nthree\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.max), func(t *testing.T) {
+			cleanup := Cleanup{CollapseSpaces: true, TrimLines: true, MaxBlankLines: tt.max}
+			text, err := ConvertFile(path, Options{Separator: "----", Cleanup: &cleanup})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.want {
+				t.Errorf("got %q, want %q", text, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package epub
+
+import "errors"
+
+// Errors reported for books that cannot be converted, for matching with
+// errors.Is. They are usually wrapped with further detail.
+var (
+	// ErrNoContainer means META-INF/container.xml is missing
+	ErrNoContainer = errors.New("container.xml file not found in EPUB")
+
+	// ErrNoRootfile means container.xml names no package document
+	ErrNoRootfile = errors.New("no rootfile found in container.xml")
+
+	// ErrOPFNotFound means the package document named by container.xml
+	// is missing from the archive
+	ErrOPFNotFound = errors.New("OPF file not found")
+
+	// ErrTooLarge means the book decompresses to more than
+	// Options.MaxUncompressedBytes
+	ErrTooLarge = errors.New("EPUB content exceeds the uncompressed size limit")
+
+	// ErrDRM means the book's content is encrypted
+	ErrDRM = errors.New("EPUB appears to be DRM-protected and cannot be converted")
+
+	// ErrMOBI means the input is a Kindle book (MOBI, AZW, or AZW3)
+	// rather than an EPUB
+	ErrMOBI = errors.New("input is a Kindle (MOBI/AZW3) book, not an EPUB; convert it to EPUB first, for example with Calibre's ebook-convert")
+)
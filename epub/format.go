@@ -0,0 +1,330 @@
+package epub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Output formats
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+
+	// FormatAnnotated is plain text with each line prefixed by the href
+	// and the element it came from, such as "[chap3.xhtml p] ", for
+	// tracking down why text appears or goes missing
+	FormatAnnotated = "annotated"
+)
+
+// Link modes
+const (
+	LinksInline    = "inline"
+	LinksFootnotes = "footnotes"
+)
+
+// Chapter is the text extracted from a single spine item. Index is its
+// 1-based position among the chapters being extracted.
+type Chapter struct {
+	Index int    `json:"index"`
+	IDRef string `json:"idref"`
+	Href  string `json:"href"`
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text"`
+
+	position int // 1-based spine position, or 0 for books read without a spine
+}
+
+// Book is the extracted content of an EPUB in reading order
+type Book struct {
+	Metadata Metadata    `json:"metadata"`
+	TOC      []TOCEntry  `json:"toc,omitempty"`
+	Guide    []Reference `json:"guide,omitempty"`
+	Chapters []Chapter   `json:"chapters"`
+}
+
+// MarshalJSON encodes the metadata using its display values rather
+// than the raw Dublin Core lists
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Title      string `json:"title,omitempty"`
+		Author     string `json:"author,omitempty"`
+		AuthorSort string `json:"author_sort,omitempty"`
+		Language   string `json:"language,omitempty"`
+		Identifier string `json:"identifier,omitempty"`
+		Direction  string `json:"direction,omitempty"`
+		Cover      string `json:"cover,omitempty"`
+	}{
+		Title:      m.Title(),
+		Author:     m.Author(),
+		AuthorSort: m.AuthorSort(),
+		Language:   m.Language(),
+		Identifier: m.Identifier(),
+		Direction:  m.Direction,
+		Cover:      m.Cover,
+	})
+}
+
+// Render formats the book according to opts.Format
+func (b *Book) Render(opts Options) (string, error) {
+	var text strings.Builder
+	bw, err := newBookWriter(&text, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if err := bw.begin(b); err != nil {
+		return "", err
+	}
+	for _, chapter := range b.Chapters {
+		if err := bw.chapter(chapter); err != nil {
+			return "", err
+		}
+	}
+	if err := bw.end(); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// bookWriter streams a book in some output format, one chapter at a time
+type bookWriter interface {
+	// begin writes everything preceding the chapters; the book's
+	// Chapters are ignored
+	begin(book *Book) error
+	chapter(chapter Chapter) error
+	end() error
+}
+
+func newBookWriter(w io.Writer, opts Options) (bookWriter, error) {
+	switch opts.Format {
+	case "", FormatText, FormatMarkdown, FormatAnnotated:
+		return &textWriter{w: w, opts: opts}, nil
+	case FormatJSON:
+		return &jsonWriter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", opts.Format)
+	}
+}
+
+// textWriter writes plain text or Markdown
+type textWriter struct {
+	w        io.Writer
+	opts     Options
+	chapters int
+}
+
+func (t *textWriter) begin(book *Book) error {
+	var textContent strings.Builder
+
+	// Front matter has to come first for static site generators to see it
+	if t.opts.FrontMatter && t.opts.Format == FormatMarkdown {
+		textContent.WriteString(formatFrontMatter(book.Metadata))
+		textContent.WriteString(t.blank())
+	}
+
+	// Start with an overview of the whole book
+	if t.opts.Summary {
+		textContent.WriteString(formatSummary(book))
+		textContent.WriteString(t.blank())
+	}
+
+	// Emit the metadata header first if requested
+	if t.opts.Metadata {
+		if header := book.Metadata.Header(); header != "" {
+			textContent.WriteString(header)
+			textContent.WriteString(t.blank())
+		}
+	}
+
+	// Then the table of contents
+	if t.opts.TOC && len(book.TOC) > 0 {
+		textContent.WriteString(formatTOC(book.TOC))
+		textContent.WriteString(t.blank())
+	}
+
+	return t.write(textContent.String())
+}
+
+// formatSummary lists the book's title, author, and chapters with their
+// word counts
+func formatSummary(book *Book) string {
+	var summary strings.Builder
+	summary.WriteString("Summary\n")
+	if title := book.Metadata.Title(); title != "" {
+		fmt.Fprintf(&summary, "Title: %s\n", title)
+	}
+	if author := book.Metadata.Author(); author != "" {
+		fmt.Fprintf(&summary, "Author: %s\n", author)
+	}
+
+	total := 0
+	for i, chapter := range book.Chapters {
+		words, _, _ := Stats(chapter.Text)
+		total += words
+		name := chapter.Title
+		if name == "" {
+			name = chapter.Href
+		}
+		fmt.Fprintf(&summary, "  %d. %s (%d words)\n", i+1, name, words)
+	}
+	fmt.Fprintf(&summary, "Total: %d words\n", total)
+	return summary.String()
+}
+
+// formatFrontMatter renders the metadata as a YAML front matter block.
+// Values are written as double-quoted strings, whose escapes Go's quoting
+// matches, so any character in them is safe.
+func formatFrontMatter(m Metadata) string {
+	var matter strings.Builder
+	matter.WriteString("---\n")
+	fields := []struct{ name, value string }{
+		{"title", m.Title()},
+		{"author", m.Author()},
+		{"author_sort", m.AuthorSort()},
+		{"language", m.Language()},
+		{"identifier", m.Identifier()},
+	}
+	for _, field := range fields {
+		if field.value != "" {
+			fmt.Fprintf(&matter, "%s: %s\n", field.name, strconv.Quote(field.value))
+		}
+	}
+	matter.WriteString("---\n")
+	return matter.String()
+}
+
+func (t *textWriter) chapter(chapter Chapter) error {
+	var textContent strings.Builder
+
+	// Mark the boundary with the previous chapter
+	if t.chapters > 0 && t.opts.Separator != "" {
+		textContent.WriteString(t.opts.Separator)
+		textContent.WriteString("\n" + t.blank())
+	}
+	t.chapters++
+
+	// Title the chapter from the table of contents, numbering it if asked
+	title := ""
+	if t.opts.ChapterTitles {
+		title = chapter.Title
+	}
+	if t.opts.NumberChapters {
+		n := chapter.position
+		if n == 0 {
+			n = chapter.Index
+		}
+		number := "Chapter " + strconv.Itoa(n)
+		if title != "" {
+			title = number + ": " + title
+		} else {
+			title = number
+		}
+	}
+	if title != "" {
+		if t.opts.Format == FormatMarkdown {
+			textContent.WriteString("# ")
+		}
+		textContent.WriteString(title)
+		textContent.WriteString("\n" + t.blank())
+	}
+
+	textContent.WriteString(chapter.Text)
+	textContent.WriteString("\n" + t.blank())
+
+	return t.write(textContent.String())
+}
+
+func (t *textWriter) end() error {
+	return nil
+}
+
+// blank returns the blank line written between the parts of the output,
+// which are run together when Cleanup.MaxBlankLines is 0
+func (t *textWriter) blank() string {
+	if t.opts.cleanup().MaxBlankLines == 0 {
+		return ""
+	}
+	return "\n"
+}
+
+// textLineEndings normalizes the line endings carried over from the
+// source files and drops byte order marks, which only belong at the
+// start of a file and are never written there
+var textLineEndings = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\ufeff", "")
+
+func (t *textWriter) write(text string) error {
+	text = textLineEndings.Replace(text)
+	if _, err := io.WriteString(t.w, text); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+// jsonWriter writes the book as a JSON object, encoding each chapter
+// into the "chapters" array as it arrives
+type jsonWriter struct {
+	w        io.Writer
+	chapters int
+}
+
+func (j *jsonWriter) begin(book *Book) error {
+	var head strings.Builder
+	head.WriteString("{\n")
+
+	metadata, err := json.MarshalIndent(book.Metadata, "  ", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	fmt.Fprintf(&head, "  \"metadata\": %s,\n", metadata)
+
+	if len(book.TOC) > 0 {
+		toc, err := json.MarshalIndent(book.TOC, "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		fmt.Fprintf(&head, "  \"toc\": %s,\n", toc)
+	}
+
+	if len(book.Guide) > 0 {
+		guide, err := json.MarshalIndent(book.Guide, "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		fmt.Fprintf(&head, "  \"guide\": %s,\n", guide)
+	}
+
+	head.WriteString("  \"chapters\": [")
+	return j.write(head.String())
+}
+
+func (j *jsonWriter) chapter(chapter Chapter) error {
+	data, err := json.MarshalIndent(chapter, "    ", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+
+	sep := "\n    "
+	if j.chapters > 0 {
+		sep = ",\n    "
+	}
+	j.chapters++
+	return j.write(sep + string(data))
+}
+
+func (j *jsonWriter) end() error {
+	if j.chapters == 0 {
+		return j.write("]\n}\n")
+	}
+	return j.write("\n  ]\n}\n")
+}
+
+func (j *jsonWriter) write(text string) error {
+	if _, err := io.WriteString(j.w, text); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package epub
+
+import (
+	"strings"
+	"unicode"
+)
+
+// headLimit counts down the words or characters of Options.HeadWords or
+// Options.HeadChars still to be extracted
+type headLimit struct {
+	words bool
+	left  int
+}
+
+// newHeadLimit returns the limit opts sets, or nil for the whole book
+func newHeadLimit(opts Options) *headLimit {
+	switch {
+	case opts.HeadWords > 0:
+		return &headLimit{words: true, left: opts.HeadWords}
+	case opts.HeadChars > 0:
+		return &headLimit{left: opts.HeadChars}
+	}
+	return nil
+}
+
+// take returns as much of text as the limit has left, and whether the
+// limit has now been reached
+func (h *headLimit) take(text string) (string, bool) {
+	end, count := len(text), 0
+	inWord := false
+	for i, r := range text {
+		if h.words {
+			space := unicode.IsSpace(r)
+			if !space && !inWord {
+				if count == h.left {
+					end = i
+					break
+				}
+				count++
+			}
+			inWord = !space
+			continue
+		}
+		if count == h.left {
+			end = i
+			break
+		}
+		count++
+	}
+
+	h.left -= count
+	if end < len(text) {
+		return strings.TrimRightFunc(text[:end], unicode.IsSpace), true
+	}
+	return text, h.left == 0
+}
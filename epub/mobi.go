@@ -0,0 +1,25 @@
+package epub
+
+import (
+	"bytes"
+	"io"
+)
+
+// kindleTypes are the type and creator codes that the PalmDB header of
+// a MOBI, AZW, or AZW3 book carries at offset 60
+var kindleTypes = [][]byte{[]byte("BOOKMOBI"), []byte("TEXtREAd")}
+
+// checkMOBI returns ErrMOBI when r holds a Kindle book rather than an
+// EPUB, to explain why it could not be opened as a ZIP archive
+func checkMOBI(r io.ReaderAt) error {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 60); err != nil {
+		return nil
+	}
+	for _, kindleType := range kindleTypes {
+		if bytes.Equal(header, kindleType) {
+			return ErrMOBI
+		}
+	}
+	return nil
+}
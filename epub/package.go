@@ -0,0 +1,375 @@
+package epub
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Package metadata structure
+type Package struct {
+	XMLName          xml.Name `xml:"package"`
+	Version          string   `xml:"version,attr"`
+	UniqueIdentifier string   `xml:"unique-identifier,attr"`
+	Metadata         Metadata `xml:"metadata"`
+	Manifest         Manifest `xml:"manifest"`
+	Spine            Spine    `xml:"spine"`
+	Guide            Guide    `xml:"guide"`
+}
+
+// Metadata holds the Dublin Core fields from the OPF metadata section
+type Metadata struct {
+	Titles      []string     `xml:"title"`
+	Creators    []Creator    `xml:"creator"`
+	Languages   []string     `xml:"language"`
+	Identifiers []Identifier `xml:"identifier"`
+	Metas       []Meta       `xml:"meta"`
+
+	// UniqueID is the id of the identifier the package names as its
+	// unique-identifier
+	UniqueID string `xml:"-"`
+
+	// Direction is the spine's page-progression-direction ("ltr" or
+	// "rtl"), copied here so it is reported with the other metadata
+	Direction string `xml:"-"`
+
+	// Cover is the alt text or title of the cover image, which often
+	// carries a subtitle or series name found nowhere else. It is only
+	// read when Options.Metadata is set.
+	Cover string `xml:"-"`
+}
+
+// Creator is a dc:creator. FileAs is the sortable form of the name, such
+// as "Tolkien, J. R. R.", from EPUB 2's opf:file-as attribute; EPUB 3
+// books give it in a <meta property="file-as"> refining the creator's ID.
+type Creator struct {
+	ID     string `xml:"id,attr"`
+	FileAs string `xml:"file-as,attr"`
+	Name   string `xml:",chardata"`
+}
+
+// Identifier is a dc:identifier such as an ISBN or UUID, with the scheme
+// given by EPUB 2's opf:scheme attribute when present
+type Identifier struct {
+	ID     string `xml:"id,attr"`
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// Meta is a <meta> element of the package metadata: EPUB 2 books use
+// name and content, EPUB 3 books property and the element's text
+type Meta struct {
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// Title returns the first title of the book
+func (m Metadata) Title() string {
+	return firstNonEmpty(m.Titles)
+}
+
+// Author returns all creators joined with commas
+func (m Metadata) Author() string {
+	var authors []string
+	for _, creator := range m.Creators {
+		if name := strings.TrimSpace(creator.Name); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	return strings.Join(authors, ", ")
+}
+
+// AuthorSort returns the sortable forms of all creators' names joined
+// with semicolons, since the names themselves contain commas. Creators
+// without a sortable form are given by name, and "" is returned when
+// none of them has one.
+func (m Metadata) AuthorSort() string {
+	var names []string
+	found := false
+	for _, creator := range m.Creators {
+		name := strings.TrimSpace(creator.Name)
+		if name == "" {
+			continue
+		}
+		if fileAs := m.fileAs(creator); fileAs != "" {
+			name = fileAs
+			found = true
+		}
+		names = append(names, name)
+	}
+	if !found {
+		return ""
+	}
+	return strings.Join(names, "; ")
+}
+
+// fileAs returns the sortable form of the creator's name, from its
+// attribute or an EPUB 3 refining meta
+func (m Metadata) fileAs(creator Creator) string {
+	if fileAs := strings.TrimSpace(creator.FileAs); fileAs != "" {
+		return fileAs
+	}
+	if creator.ID == "" {
+		return ""
+	}
+	for _, meta := range m.Metas {
+		if meta.Property == "file-as" && meta.Refines == "#"+creator.ID {
+			return strings.TrimSpace(meta.Value)
+		}
+	}
+	return ""
+}
+
+// Language returns the first language of the book
+func (m Metadata) Language() string {
+	return firstNonEmpty(m.Languages)
+}
+
+// Identifier returns the book's unique identifier, falling back to the
+// first identifier when the package doesn't say which is unique
+func (m Metadata) Identifier() string {
+	for _, id := range m.Identifiers {
+		if id.ID != "" && id.ID == m.UniqueID {
+			if value := strings.TrimSpace(id.Value); value != "" {
+				return value
+			}
+		}
+	}
+	for _, id := range m.Identifiers {
+		if value := strings.TrimSpace(id.Value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Header formats the metadata as a block of "Field: value" lines,
+// omitting any fields that are missing
+func (m Metadata) Header() string {
+	var header strings.Builder
+	fields := []struct{ name, value string }{
+		{"Title", m.Title()},
+		{"Author", m.Author()},
+		{"Author Sort", m.AuthorSort()},
+		{"Language", m.Language()},
+		{"Identifier", m.Identifier()},
+		{"Direction", m.Direction},
+		{"Cover", m.Cover},
+	}
+	for _, field := range fields {
+		if field.value != "" {
+			fmt.Fprintf(&header, "%s: %s\n", field.name, field.value)
+		}
+	}
+	return header.String()
+}
+
+func firstNonEmpty(values []string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+type Manifest struct {
+	Items []Item `xml:"item"`
+}
+
+type Item struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+// IsEPUB2 reports whether the package declares itself an EPUB 2 (or
+// earlier OEB) package rather than EPUB 3
+func (p *Package) IsEPUB2() bool {
+	return p.Version != "" && p.Version < "3"
+}
+
+// HasProperty reports whether the item's space-separated properties
+// include the given value
+func (i Item) HasProperty(property string) bool {
+	for _, p := range strings.Fields(i.Properties) {
+		if p == property {
+			return true
+		}
+	}
+	return false
+}
+
+type Spine struct {
+	TOC                      string    `xml:"toc,attr"`
+	PageProgressionDirection string    `xml:"page-progression-direction,attr"`
+	ItemRefs                 []ItemRef `xml:"itemref"`
+}
+
+type ItemRef struct {
+	IDRef  string `xml:"idref,attr"`
+	Linear string `xml:"linear,attr"`
+}
+
+// IsLinear reports whether the item is part of the main reading order
+func (r ItemRef) IsLinear() bool {
+	return r.Linear != "no"
+}
+
+// Guide is the EPUB 2 guide, which points to landmark pages of the book
+// such as the cover, the table of contents, and the start of the text
+type Guide struct {
+	References []Reference `xml:"reference"`
+}
+
+// Reference is a single landmark of the guide. Type is one of the values
+// defined by the OPF spec, such as "cover", "toc", or "text", and Href is
+// relative to the package document.
+type Reference struct {
+	Type  string `xml:"type,attr" json:"type"`
+	Title string `xml:"title,attr" json:"title,omitempty"`
+	Href  string `xml:"href,attr" json:"href"`
+}
+
+// Reference returns the first reference of the given type, ignoring case
+func (g Guide) Reference(refType string) (Reference, bool) {
+	for _, ref := range g.References {
+		if strings.EqualFold(ref.Type, refType) {
+			return ref, true
+		}
+	}
+	return Reference{}, false
+}
+
+// Container metadata structure
+type Container struct {
+	XMLName   xml.Name  `xml:"container"`
+	RootFiles RootFiles `xml:"rootfiles"`
+}
+
+type RootFiles struct {
+	RootFile []RootFile `xml:"rootfile"`
+}
+
+// RootFile names a package document. A book with several renditions,
+// such as fixed and reflowable layouts, describes each with the
+// rendition attributes of the EPUB Multiple-Rendition spec.
+type RootFile struct {
+	FullPath   string `xml:"full-path,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Label      string `xml:"label,attr"`
+	Language   string `xml:"language,attr"`
+	Layout     string `xml:"layout,attr"`
+	Media      string `xml:"media,attr"`
+	AccessMode string `xml:"accessMode,attr"`
+}
+
+// UnmarshalXML decodes container.xml, matching element and attribute
+// names regardless of their namespace or case, since some publishers'
+// tools differ from the OCF spec in both
+func (c *Container) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if !strings.EqualFold(start.Name.Local, "container") {
+		return fmt.Errorf("expected element type <container> but have <%s>", start.Name.Local)
+	}
+	c.XMLName = start.Name
+
+	for depth := 0; depth >= 0; {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if strings.EqualFold(t.Name.Local, "rootfile") {
+				c.RootFiles.RootFile = append(c.RootFiles.RootFile, RootFile{
+					FullPath:   foldedAttr(t, "full-path"),
+					MediaType:  foldedAttr(t, "media-type"),
+					Label:      foldedAttr(t, "label"),
+					Language:   foldedAttr(t, "language"),
+					Layout:     foldedAttr(t, "layout"),
+					Media:      foldedAttr(t, "media"),
+					AccessMode: foldedAttr(t, "accessMode"),
+				})
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
+// foldedAttr returns the value of the attribute of e with the given local
+// name, ignoring its namespace and case
+func foldedAttr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if strings.EqualFold(a.Name.Local, name) {
+			return strings.TrimSpace(a.Value)
+		}
+	}
+	return ""
+}
+
+// ParseContainer reads an OCF container document, META-INF/container.xml,
+// from r
+func ParseContainer(r io.Reader) (*Container, error) {
+	return readContainer(r, Options{})
+}
+
+// ParsePackage reads an OPF package document from r
+func ParsePackage(r io.Reader) (*Package, error) {
+	return readPackage(r, Options{})
+}
+
+func parseContainer(containerFile *archiveFile, opts Options) (*Container, error) {
+	reader, err := containerFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container.xml: %w", err)
+	}
+	defer reader.Close()
+	return readContainer(reader, opts)
+}
+
+func readContainer(r io.Reader, opts Options) (*Container, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container.xml: %w", err)
+	}
+
+	var container Container
+	err = unmarshalXML(data, &container, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse container.xml: %w", err)
+	}
+
+	return &container, nil
+}
+
+func parsePackage(opfFile *archiveFile, opts Options) (*Package, error) {
+	reader, err := opfFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OPF file: %w", err)
+	}
+	defer reader.Close()
+	return readPackage(reader, opts)
+}
+
+func readPackage(r io.Reader, opts Options) (*Package, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OPF file: %w", err)
+	}
+
+	var pkg Package
+	err = unmarshalXML(data, &pkg, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OPF file: %w", err)
+	}
+
+	return &pkg, nil
+}
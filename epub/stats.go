@@ -0,0 +1,17 @@
+package epub
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Stats counts the words, characters, and lines in text
+func Stats(text string) (words, chars, lines int) {
+	words = len(strings.Fields(text))
+	chars = utf8.RuneCountInString(text)
+	lines = strings.Count(text, "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		lines++
+	}
+	return words, chars, lines
+}
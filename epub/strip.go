@@ -0,0 +1,32 @@
+package epub
+
+import (
+	"html"
+	"regexp"
+)
+
+// minRecoveredText is the least text, in characters, that stripping the
+// tags must find before it replaces the parser's text
+const minRecoveredText = 200
+
+var (
+	// hiddenContent matches comments and the elements whose content is
+	// not part of the text
+	hiddenContent = regexp.MustCompile(`(?is)<!--.*?-->|<(?:head|script|style|svg)\b.*?</(?:head|script|style|svg)\s*>`)
+
+	// blockTag matches the tags that start or end a paragraph or line
+	blockTag = regexp.MustCompile(`(?i)</?(?:p|div|h[1-6]|li|dt|dd|tr|br|hr|blockquote|pre|section|article|table|ul|ol|dl)\b[^>]*>`)
+
+	// anyTag matches any remaining tag, closed or not
+	anyTag = regexp.MustCompile(`(?s)<[^>]*>?`)
+)
+
+// stripTags extracts the text of an HTML document with regular
+// expressions instead of a parser, for files the parser makes little of
+func stripTags(data []byte, opts Options) string {
+	text := hiddenContent.ReplaceAll(data, nil)
+	text = whitespace.ReplaceAll(text, []byte(" "))
+	text = blockTag.ReplaceAll(text, []byte("\n\n"))
+	text = anyTag.ReplaceAll(text, nil)
+	return cleanText(html.UnescapeString(string(text)), opts)
+}
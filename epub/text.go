@@ -0,0 +1,953 @@
+package epub
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	// whitespace matches runs of ASCII whitespace within text. Non-breaking
+	// spaces are not matched, so they are never collapsed.
+	whitespace = regexp.MustCompile(`\s+`)
+
+	// lineSpace matches runs of ASCII whitespace other than newlines
+	lineSpace = regexp.MustCompile(`[^\S\n]+`)
+)
+
+// nbsp is the non-breaking space, which French typography places before
+// some punctuation and some books use for indentation
+const nbsp = "\u00a0"
+
+// preMark starts each line of preformatted text while the text is being
+// extracted, so that cleanText leaves the line untouched
+const preMark = "\x00"
+
+// rowMark starts each line holding a table row while the text is being
+// extracted, so that wrapping leaves the row on one line
+const rowMark = "\x03"
+
+// extractionMarks removes the line marks once the text is finished
+var extractionMarks = strings.NewReplacer(preMark, "", rowMark, "")
+
+// annotationMark encloses the name of the element a line of text came
+// from, written once per line for FormatAnnotated
+const annotationMark = "\x02"
+
+// annotation matches an annotationMark-enclosed element name
+var annotation = regexp.MustCompile(annotationMark + "([^" + annotationMark + "]*)" + annotationMark)
+
+// lineElements are the elements, besides block elements, that
+// FormatAnnotated reports as the source of their lines
+var lineElements = map[string]bool{
+	"li": true, "dt": true, "tr": true, "td": true, "th": true,
+}
+
+// defaultBlockElements are the elements set on lines of their own unless
+// Options.BlockElements says otherwise
+var defaultBlockElements = map[string]bool{
+	"p": true, "div": true, "table": true, "caption": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"section": true, "article": true, "header": true, "footer": true,
+	"aside": true, "nav": true, "main": true, "address": true,
+	"figure": true, "figcaption": true, "dl": true,
+}
+
+// DefaultBlockElements returns a copy of the elements set on lines of
+// their own by default, for adjusting and passing as
+// Options.BlockElements
+func DefaultBlockElements() map[string]bool {
+	return maps.Clone(defaultBlockElements)
+}
+
+// extractor walks an HTML tree and accumulates its text
+type extractor struct {
+	builder  bytes.Buffer
+	opts     Options
+	markdown bool
+	lists    []listState
+	tables   []tableState
+	links    []string        // link targets numbered as footnotes so far
+	emphasis []string        // Markdown emphasis markers currently open
+	anchors  map[string]bool // ids at which to mark chapter boundaries
+
+	// annotated marks each line with the innermost block element open
+	// around its first text, for FormatAnnotated
+	annotated bool
+	blocks    []string
+
+	// A space is written after every text node. It is taken back when the
+	// next text node follows on directly in the source, as in
+	// "<a>world</a>!", so words aren't split apart at inline elements, and
+	// when it starts with punctuation such as a comma, which belongs
+	// against the word before it even when the source breaks the line.
+	spaceAt   int  // length of the builder just after that space
+	spaceNext bool // whether the source had whitespace after the last text node
+
+	// markerAt is the length of the builder just after the last list
+	// marker, so blocks opening an item, such as its <p>, don't move its
+	// text to the next line
+	markerAt int
+
+	// filtered is set once text has been left out on purpose, as asked
+	// for by the options, so a short result is not taken for a
+	// malformed file
+	filtered bool
+}
+
+// tableState tracks an open <table> while walking the tree
+type tableState struct {
+	cells     int  // cells written so far in the current row
+	cell      bool // whether a cell is open, whose blocks stay on the row's line
+	header    bool // whether the current row has <th> cells
+	separated bool // whether the Markdown header separator was written
+}
+
+// listState tracks an open <ul> or <ol> while walking the tree
+type listState struct {
+	ordered bool
+	items   int
+	marker  int // width of the current item's marker, for indenting
+}
+
+// extractTextFromHTMLFile returns the cleaned text of an HTML file. The
+// text is marked where each of the anchors appears, for splitSections.
+func extractTextFromHTMLFile(htmlFile *archiveFile, anchors []tocAnchor, opts Options) (string, error) {
+	reader, err := htmlFile.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open HTML file: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read HTML file: %w", err)
+	}
+
+	// Parse HTML, keeping the transcoded text for the fallback below
+	data = toUTF8(data, opts)
+	doc, err := parseUTF8HTML(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	// Extract text
+	e := &extractor{
+		opts:      opts,
+		markdown:  opts.Format == FormatMarkdown,
+		annotated: opts.Format == FormatAnnotated,
+	}
+	if len(anchors) > 0 {
+		e.anchors = make(map[string]bool)
+		for _, anchor := range anchors {
+			e.anchors[anchor.id] = true
+		}
+	}
+	e.extractText(doc)
+
+	text := cleanText(e.builder.String(), opts)
+	if opts.wraps() {
+		text = wrapText(text, opts.Wrap, e.markdown)
+	}
+	text = extractionMarks.Replace(text)
+
+	// A truncated file can leave the parser inside an element whose
+	// content is never shown, such as an unclosed <title>, losing the
+	// rest of the text. Fall back to stripping the tags when the text
+	// found is suspiciously short for the size of the file, unless the
+	// options left text out on purpose, which stripping would bring back.
+	if !e.filtered && len(text) < len(data)/10 {
+		stripped := stripTags(data, opts)
+		found, expected := utf8.RuneCountInString(text), utf8.RuneCountInString(stripped)
+		if expected >= minRecoveredText && found < expected/4 {
+			opts.warnf("%s gave only %d of about %d characters of text and may be malformed; stripping its tags instead", htmlFile.Name, found, expected)
+			if opts.wraps() {
+				stripped = wrapText(stripped, opts.Wrap, false)
+			}
+			return stripped, nil
+		}
+	}
+
+	if len(e.links) > 0 {
+		text += "\n\n" + formatLinks(e.links)
+	}
+	return text, nil
+}
+
+// cleanText tidies the whitespace of extracted text according to
+// opts.Cleanup, keeping the line breaks inserted for block elements. By
+// default, whitespace is collapsed within lines and trimmed from their
+// ends, leaving a single blank line between paragraphs. Leading
+// indentation and quote markers, which only the extractor writes, are
+// kept, as are preformatted lines. Runs of non-breaking spaces are kept
+// within lines and, if opts.KeepIndent is set, at their start.
+func cleanText(text string, opts Options) string {
+	cleanup := opts.cleanup()
+	var cleanLines []string
+	blanks := 0
+	for _, line := range strings.Split(text, "\n") {
+		content := strings.TrimLeft(line, " >")
+		prefix := line[:len(line)-len(content)]
+
+		cleanLine := line
+		if !strings.HasPrefix(content, preMark) {
+			row := strings.HasPrefix(content, rowMark)
+			content = strings.TrimPrefix(content, rowMark)
+			if cleanup.CollapseSpaces {
+				content = lineSpace.ReplaceAllString(content, " ")
+			}
+			if cleanup.TrimLines {
+				content = strings.TrimRight(content, " "+nbsp)
+				if opts.KeepIndent {
+					content = strings.TrimLeft(content, " ")
+				} else {
+					content = strings.TrimLeft(content, " "+nbsp)
+				}
+			}
+			cleanLine = prefix + content
+			if strings.TrimSpace(content) == "" {
+				// A quoted blank line is still part of the quote
+				cleanLine = strings.TrimSpace(prefix)
+			} else if row {
+				cleanLine = prefix + rowMark + content
+			}
+		}
+
+		// Squeeze runs of blank lines, dropping those at the start
+		if cleanLine == "" {
+			if len(cleanLines) > 0 {
+				blanks++
+			}
+			continue
+		}
+		if cleanup.MaxBlankLines >= 0 {
+			blanks = min(blanks, cleanup.MaxBlankLines)
+		}
+		for ; blanks > 0; blanks-- {
+			cleanLines = append(cleanLines, "")
+		}
+		cleanLines = append(cleanLines, cleanLine)
+	}
+
+	return strings.Join(cleanLines, "\n")
+}
+
+func (e *extractor) extractText(n *html.Node) {
+	if n.Type == html.TextNode {
+		text := e.nodeText(n.Data)
+		if text != "" {
+			// A non-breaking space binds to the neighboring text, so no
+			// ordinary space is put beside it
+			if strings.HasPrefix(text, nbsp) {
+				e.trimSpace()
+			}
+			attached := !e.spaceNext && !startsWithSpace(n.Data)
+			if (attached || startsWithPunct(text)) && e.builder.Len() == e.spaceAt {
+				e.trimSpace()
+			}
+			e.annotate()
+			e.builder.WriteString(text)
+			if !strings.HasSuffix(text, nbsp) {
+				e.builder.WriteString(" ")
+				e.spaceAt = e.builder.Len()
+			}
+		}
+		e.spaceNext = text == "" || endsWithSpace(n.Data)
+	}
+
+	// Mark print page boundaries at the page break element
+	if n.Type == html.ElementNode && e.opts.PageMarkers && isPageBreak(n) {
+		if page := pageNumber(n); page != "" {
+			e.annotate()
+			e.builder.WriteString("[Page " + page + "] ")
+
+			// Skip the element's own copy of the page number. Other
+			// children are kept: a self-closing <span/> parsed as HTML
+			// swallows the content that follows it.
+			if strings.TrimSpace(nodeText(n)) == page {
+				return
+			}
+		}
+	}
+
+	// Skip content hidden from readers, usually decorative or repeated,
+	// unless it was asked for
+	if n.Type == html.ElementNode && !e.opts.IncludeHidden && isHidden(n) {
+		e.skip(n)
+		return
+	}
+
+	// Drop footnote markers when asked, along with their superscript
+	if n.Type == html.ElementNode && e.opts.StripFootnoteRefs && isFootnoteRef(n) {
+		e.skip(n)
+		return
+	}
+
+	// Leave out the front and back matter when only the body is wanted
+	if n.Type == html.ElementNode && e.opts.OnlyBodymatter && isFrontOrBackMatter(n) {
+		e.skip(n)
+		return
+	}
+
+	// Mark where a chapter starts within the file
+	if n.Type == html.ElementNode && e.anchors[attr(n, "id")] {
+		e.builder.WriteString("\n" + anchorMark + attr(n, "id") + "\n")
+	}
+
+	// Check if this node is a block element that should add a line break
+	if n.Type == html.ElementNode {
+		switch n.Data {
+		case "script", "style", "head", "title":
+			// Skip non-content elements and everything inside them
+			return
+		case "blockquote":
+			e.extractQuote(n)
+			return
+		case "pre":
+			e.extractPre(n)
+			return
+		case "dd":
+			e.extractDefinition(n)
+			return
+		case "em", "i", "strong", "b":
+			// Markdown keeps emphasis, unless it spans whole paragraphs
+			if e.markdown && !e.hasBlocks(n) {
+				e.extractEmphasis(n)
+				return
+			}
+		case "svg":
+			// Drawings are left out unless their labels were asked for
+			if e.opts.SVGText {
+				e.extractSVG(n)
+			} else {
+				e.skip(n)
+			}
+			return
+		case "rp":
+			// Fallback parentheses for readers without ruby support
+			return
+		case "rt":
+			// Ruby glosses such as furigana are dropped unless requested
+			if gloss := strings.TrimSpace(nodeText(n)); gloss != "" && e.opts.RubyText {
+				e.trimSpace()
+				e.builder.WriteString("(" + gloss + ") ")
+				e.spaceAt = e.builder.Len()
+			} else {
+				e.skip(n)
+			}
+			return
+		case "img":
+			// Keep the image's description in the flow of the text
+			if alt := strings.TrimSpace(attr(n, "alt")); alt != "" && e.opts.AltText {
+				e.annotate()
+				e.builder.WriteString("[Image: " + alt + "] ")
+			}
+		case "hr":
+			// Thematic breaks stand on a line of their own
+			e.builder.WriteString("\n\n" + e.mark("hr") + e.rule() + "\n\n")
+		case "li":
+			// Only the first item of a list sets it apart from the text
+			// before; later and nested items need only start a new line
+			if len(e.lists) == 0 || len(e.lists) == 1 && e.lists[0].items == 0 {
+				e.breakLine()
+			} else {
+				e.endLine()
+			}
+		case "br", "dt":
+			if e.inCell() {
+				e.builder.WriteString(" ")
+			} else {
+				e.builder.WriteString("\n")
+			}
+		default:
+			if e.isBlock(n.Data) {
+				e.breakLine()
+			}
+		}
+		if n.Data == "figcaption" && e.opts.CaptionPrefix != "" {
+			e.builder.WriteString(e.opts.CaptionPrefix)
+		}
+
+		e.startTable(n)
+		e.startList(n)
+		if e.markdown {
+			e.startMarkdown(n)
+		}
+
+		// Mark where the book's links can point, for jumping to later
+		if e.opts.AnchorMarkers && isAnchorTarget(n.Data) {
+			if id := strings.TrimSpace(attr(n, "id")); id != "" {
+				e.builder.WriteString("[#" + id + "] ")
+			}
+		}
+	}
+
+	// Process child nodes
+	annotatedBlock := e.annotated && n.Type == html.ElementNode && (e.isBlock(n.Data) || lineElements[n.Data])
+	if annotatedBlock {
+		e.blocks = append(e.blocks, n.Data)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		e.extractText(c)
+	}
+	if annotatedBlock {
+		e.blocks = e.blocks[:len(e.blocks)-1]
+	}
+
+	// Add additional line breaks after certain elements
+	if n.Type == html.ElementNode {
+		if n.Data == "ul" || n.Data == "ol" {
+			e.endLine()
+		} else if e.isBlock(n.Data) {
+			e.breakLine()
+		}
+
+		e.endTable(n)
+		e.endList(n)
+		e.endLink(n)
+	}
+}
+
+// annotate marks the current line with the innermost open block element,
+// unless the line is already marked or annotation is off
+func (e *extractor) annotate() {
+	if !e.annotated {
+		return
+	}
+	text := e.builder.Bytes()
+	if bytes.Contains(text[bytes.LastIndexByte(text, '\n')+1:], []byte(annotationMark)) {
+		return
+	}
+	tag := "body"
+	if len(e.blocks) > 0 {
+		tag = e.blocks[len(e.blocks)-1]
+	}
+	e.builder.WriteString(e.mark(tag))
+}
+
+// mark returns the annotation naming tag as a line's source, or "" when
+// annotation is off
+func (e *extractor) mark(tag string) string {
+	if !e.annotated {
+		return ""
+	}
+	return annotationMark + tag + annotationMark
+}
+
+// annotateLines replaces the annotation marks in text with a prefix
+// naming href and the element each line came from
+func annotateLines(text, href string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if m := annotation.FindStringSubmatch(line); m != nil {
+			lines[i] = "[" + href + " " + m[1] + "] " + annotation.ReplaceAllString(line, "")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// skip records that n was left out of the text on purpose, if it held
+// any text
+func (e *extractor) skip(n *html.Node) {
+	if !e.filtered && strings.TrimSpace(nodeText(n)) != "" {
+		e.filtered = true
+	}
+}
+
+// breakLine ends the current line at a block boundary, unless only a
+// list marker has been written on it. Within a table cell the blocks are
+// only spaced apart, keeping the row on one line.
+func (e *extractor) breakLine() {
+	switch {
+	case e.inCell():
+		e.builder.WriteString(" ")
+	case e.builder.Len() != e.markerAt:
+		e.builder.WriteString("\n")
+	}
+}
+
+// endLine starts a new line, dropping any blank lines the blocks just
+// closed left behind, so list items follow one another directly
+func (e *extractor) endLine() {
+	if e.inCell() {
+		e.builder.WriteString(" ")
+		return
+	}
+	e.builder.Truncate(len(bytes.TrimRight(e.builder.Bytes(), "\n")))
+	e.builder.WriteString("\n")
+}
+
+// trimSpace removes the space written after the last text node, to
+// attach what follows directly to it
+func (e *extractor) trimSpace() {
+	e.builder.Truncate(len(bytes.TrimRight(e.builder.Bytes(), " ")))
+}
+
+// startsWithSpace reports whether s begins with HTML whitespace
+func startsWithSpace(s string) bool {
+	return s != "" && strings.TrimLeft(s, " \t\r\n\f") != s
+}
+
+// startsWithPunct reports whether s begins with punctuation that is
+// written against the preceding word
+func startsWithPunct(s string) bool {
+	return s != "" && strings.ContainsRune(",.;:!?", rune(s[0]))
+}
+
+// endsWithSpace reports whether s ends with HTML whitespace
+func endsWithSpace(s string) bool {
+	return s != "" && strings.TrimRight(s, " \t\r\n\f") != s
+}
+
+// isBlock reports whether the element named tag starts and ends a line
+func (e *extractor) isBlock(tag string) bool {
+	if e.opts.BlockElements != nil {
+		return e.opts.BlockElements[tag]
+	}
+	return defaultBlockElements[tag]
+}
+
+// nodeText prepares the content of a text node for the output. Source
+// line breaks inside a text node are not structural, so they are only
+// kept when opts.Cleanup says to.
+func (e *extractor) nodeText(data string) string {
+	cleanup := e.opts.cleanup()
+	if cleanup.KeepNewlines {
+		data = strings.ReplaceAll(data, "\r\n", "\n")
+		if strings.TrimSpace(data) == "" {
+			return ""
+		}
+		if cleanup.CollapseSpaces {
+			data = lineSpace.ReplaceAllString(data, " ")
+		}
+		if cleanup.TrimLines {
+			// Strip source indentation so it isn't mistaken for a quote or
+			// list prefix
+			lines := strings.Split(data, "\n")
+			for i, line := range lines {
+				lines[i] = strings.Trim(line, " \t\r")
+			}
+			data = strings.Join(lines, "\n")
+		}
+		return strings.Trim(data, " \t\r\n")
+	}
+
+	if cleanup.CollapseSpaces {
+		data = whitespace.ReplaceAllString(data, " ")
+	} else {
+		data = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(data)
+	}
+	return strings.Trim(data, " \t")
+}
+
+// extractQuote writes a blockquote's content with every line prefixed by
+// "> ", so nested quotes gain one marker per level
+func (e *extractor) extractQuote(n *html.Node) {
+	quote := &extractor{opts: e.opts, markdown: e.markdown, links: e.links, annotated: e.annotated, blocks: []string{"blockquote"}}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		quote.extractText(c)
+	}
+	e.links = quote.links
+	e.filtered = e.filtered || quote.filtered
+
+	text := cleanText(quote.builder.String(), e.opts)
+	if text == "" {
+		return
+	}
+
+	e.builder.WriteString("\n")
+	for _, line := range strings.Split(text, "\n") {
+		e.builder.WriteString(strings.TrimRight("> "+line, " "))
+		e.builder.WriteString("\n")
+	}
+	e.builder.WriteString("\n")
+}
+
+// endLink writes the target of an external hyperlink after its text
+// according to opts.Links
+func (e *extractor) endLink(n *html.Node) {
+	if n.Data != "a" || e.opts.Links == "" {
+		return
+	}
+
+	// Relative hrefs point within the book and mean nothing in plain text
+	href := strings.TrimSpace(attr(n, "href"))
+	if !strings.Contains(href, ":") {
+		return
+	}
+
+	switch e.opts.Links {
+	case LinksInline:
+		e.builder.WriteString("[" + href + "] ")
+		e.spaceAt = e.builder.Len()
+	case LinksFootnotes:
+		e.links = append(e.links, href)
+		e.builder.WriteString("[" + strconv.Itoa(len(e.links)) + "] ")
+		e.spaceAt = e.builder.Len()
+	}
+}
+
+// extractEmphasis writes the text of an emphasis element wrapped in
+// Markdown markers: * for <em> and <i>, ** for <strong> and <b>. The
+// markers hug the text, leaving the whitespace around it outside, and
+// emphasis already open is not repeated for nested elements.
+func (e *extractor) extractEmphasis(n *html.Node) {
+	marker := "*"
+	if n.Data == "strong" || n.Data == "b" {
+		marker = "**"
+	}
+	if slices.Contains(e.emphasis, marker) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			e.extractText(c)
+		}
+		return
+	}
+
+	// The space after the preceding text may be taken back when the
+	// emphasis is attached to it, so the content starts after that text
+	before := len(bytes.TrimRight(e.builder.Bytes(), " "))
+	e.emphasis = append(e.emphasis, marker)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		e.extractText(c)
+	}
+	e.emphasis = e.emphasis[:len(e.emphasis)-1]
+
+	// Only the emphasized span is rewritten, with the markers placed
+	// inside its surrounding whitespace
+	content := string(e.builder.Bytes()[before:])
+	core := strings.TrimLeftFunc(content, unicode.IsSpace)
+	lead := content[:len(content)-len(core)]
+	core = strings.TrimRightFunc(core, unicode.IsSpace)
+	if core == "" {
+		return
+	}
+	trail := content[len(lead)+len(core):]
+	spaced := e.spaceAt == e.builder.Len()
+
+	e.builder.Truncate(before)
+	e.builder.WriteString(lead + marker + core + marker + trail)
+	if spaced {
+		e.spaceAt = e.builder.Len()
+	}
+}
+
+// hasBlocks reports whether n contains an element that starts a line of
+// its own, which inline Markdown markers cannot span
+func (e *extractor) hasBlocks(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.Data {
+		case "blockquote", "pre", "ul", "ol", "li", "dl", "dt", "dd", "hr", "table":
+			return true
+		}
+		if e.isBlock(c.Data) || e.hasBlocks(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatLinks lists footnoted link targets by number
+func formatLinks(links []string) string {
+	var text strings.Builder
+	for i, link := range links {
+		if i > 0 {
+			text.WriteString("\n")
+		}
+		fmt.Fprintf(&text, "[%d] %s", i+1, link)
+	}
+	return text.String()
+}
+
+// extractPre writes the text of a <pre> element with its line breaks and
+// indentation intact, fenced as a code block in Markdown
+func (e *extractor) extractPre(n *html.Node) {
+	var raw strings.Builder
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			raw.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			raw.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+
+	text := strings.TrimRight(raw.String(), " \t\r\n")
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+
+	e.builder.WriteString("\n")
+	if e.markdown {
+		e.builder.WriteString("```\n")
+	}
+	for _, line := range strings.Split(text, "\n") {
+		e.builder.WriteString(preMark + e.mark("pre") + strings.TrimRight(line, " \t\r") + "\n")
+	}
+	if e.markdown {
+		e.builder.WriteString("```\n")
+	}
+	e.builder.WriteString("\n")
+}
+
+// rule returns the line written for an <hr>
+func (e *extractor) rule() string {
+	if e.markdown {
+		return "---"
+	}
+	return "* * *"
+}
+
+// extractDefinition writes a <dd> indented beneath its term, or in
+// Markdown as a ": " definition
+func (e *extractor) extractDefinition(n *html.Node) {
+	def := &extractor{opts: e.opts, markdown: e.markdown, links: e.links, annotated: e.annotated, blocks: []string{"dd"}}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		def.extractText(c)
+	}
+	e.links = def.links
+	e.filtered = e.filtered || def.filtered
+
+	text := cleanText(def.builder.String(), e.opts)
+	if text == "" {
+		return
+	}
+
+	e.builder.WriteString("\n")
+	for i, line := range strings.Split(text, "\n") {
+		marker := "  "
+		if e.markdown && i == 0 {
+			marker = ": "
+		}
+		e.builder.WriteString(strings.TrimRight(marker+line, " "))
+		e.builder.WriteString("\n")
+	}
+}
+
+// extractSVG writes the labels of an inline SVG drawing, one <text>
+// element to a line. Its <tspan>s run together as in the drawing.
+func (e *extractor) extractSVG(n *html.Node) {
+	var labels []string
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "text" {
+			if label := strings.Join(strings.Fields(nodeText(n)), " "); label != "" {
+				labels = append(labels, label)
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+
+	if len(labels) == 0 {
+		return
+	}
+	e.builder.WriteString("\n" + strings.Join(labels, "\n") + "\n")
+}
+
+// isHidden reports whether n has the hidden attribute or is marked
+// aria-hidden="true"
+func isHidden(n *html.Node) bool {
+	for _, a := range n.Attr {
+		if a.Key == "hidden" || (a.Key == "aria-hidden" && strings.EqualFold(strings.TrimSpace(a.Val), "true")) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFootnoteRef reports whether n is a footnote reference: a link that
+// epub:type marks as a noteref, or a superscript holding a link whose
+// text is a short marker such as "12" or "*"
+func isFootnoteRef(n *html.Node) bool {
+	if hasEpubType(n, "noteref") {
+		return true
+	}
+	if n.Data != "sup" {
+		return false
+	}
+
+	hasLink := false
+	var findLink func(n *html.Node)
+	findLink = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			hasLink = true
+		}
+		for c := n.FirstChild; c != nil && !hasLink; c = c.NextSibling {
+			findLink(c)
+		}
+	}
+	findLink(n)
+	if !hasLink {
+		return false
+	}
+
+	marker := strings.Trim(strings.TrimSpace(nodeText(n)), "[]()")
+	return marker != "" && utf8.RuneCountInString(marker) <= 4 && !strings.Contains(marker, " ")
+}
+
+// isAnchorTarget reports whether the element named tag gets an "[#id]"
+// marker under Options.AnchorMarkers
+func isAnchorTarget(tag string) bool {
+	switch tag {
+	case "h1", "h2", "h3", "h4", "h5", "h6", "section":
+		return true
+	}
+	return false
+}
+
+// isFrontOrBackMatter reports whether n is marked with the EPUB 3
+// frontmatter or backmatter partition
+func isFrontOrBackMatter(n *html.Node) bool {
+	return (hasEpubType(n, "frontmatter") || hasEpubType(n, "backmatter")) && !hasEpubType(n, "bodymatter")
+}
+
+// isPageBreak reports whether n marks a print page boundary
+func isPageBreak(n *html.Node) bool {
+	return hasEpubType(n, "pagebreak") || attr(n, "role") == "doc-pagebreak"
+}
+
+// pageNumber returns the page number of a page break element from its
+// title or aria-label, falling back to its text
+func pageNumber(n *html.Node) string {
+	for _, value := range []string{attr(n, "title"), attr(n, "aria-label")} {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	if n.FirstChild != nil && n.FirstChild == n.LastChild && n.FirstChild.Type == html.TextNode {
+		return strings.TrimSpace(n.FirstChild.Data)
+	}
+	return ""
+}
+
+// startTable tracks table structure so each row is written on its own
+// line with its cells separated by pipes
+func (e *extractor) startTable(n *html.Node) {
+	if n.Data == "table" {
+		e.tables = append(e.tables, tableState{})
+		return
+	}
+	if len(e.tables) == 0 {
+		return
+	}
+
+	table := &e.tables[len(e.tables)-1]
+	switch n.Data {
+	case "tr":
+		table.cells = 0
+		table.header = false
+		e.builder.WriteString(rowMark)
+		if e.markdown {
+			e.builder.WriteString("| ")
+		}
+	case "td", "th":
+		if table.cells > 0 {
+			e.builder.WriteString(" | ")
+		}
+		table.cells++
+		table.header = table.header || n.Data == "th"
+		table.cell = true
+	}
+}
+
+// inCell reports whether the innermost table has a cell open
+func (e *extractor) inCell() bool {
+	return len(e.tables) > 0 && e.tables[len(e.tables)-1].cell
+}
+
+// endTable finishes rows and closes tables opened by startTable
+func (e *extractor) endTable(n *html.Node) {
+	if len(e.tables) == 0 {
+		return
+	}
+
+	table := &e.tables[len(e.tables)-1]
+	switch n.Data {
+	case "table":
+		e.tables = e.tables[:len(e.tables)-1]
+	case "td", "th":
+		table.cell = false
+	case "tr":
+		if !e.markdown {
+			e.builder.WriteString("\n")
+			return
+		}
+		e.builder.WriteString(" |\n")
+
+		// Markdown tables need a separator line after the header row
+		if table.header && !table.separated && table.cells > 0 {
+			e.builder.WriteString(rowMark + strings.Repeat("| --- ", table.cells) + "|\n")
+			table.separated = true
+		}
+	}
+}
+
+// startList writes list item markers: "- " for unordered lists and
+// incrementing numbers for ordered ones, indented beneath the enclosing
+// item for nested lists
+func (e *extractor) startList(n *html.Node) {
+	switch n.Data {
+	case "ul", "ol":
+		e.lists = append(e.lists, listState{ordered: n.Data == "ol"})
+	case "li":
+		if len(e.lists) == 0 {
+			e.builder.WriteString("- ")
+			e.markerAt = e.builder.Len()
+			return
+		}
+
+		// Align nested items with the text of their parent items
+		for _, parent := range e.lists[:len(e.lists)-1] {
+			e.builder.WriteString(strings.Repeat(" ", parent.marker))
+		}
+
+		list := &e.lists[len(e.lists)-1]
+		list.items++
+		marker := "- "
+		if list.ordered {
+			marker = strconv.Itoa(list.items) + ". "
+		}
+		list.marker = len(marker)
+		e.builder.WriteString(marker)
+		e.markerAt = e.builder.Len()
+	}
+}
+
+// endList closes any list opened by startList
+func (e *extractor) endList(n *html.Node) {
+	switch n.Data {
+	case "ul", "ol":
+		if len(e.lists) > 0 {
+			e.lists = e.lists[:len(e.lists)-1]
+		}
+	}
+}
+
+// startMarkdown writes the Markdown prefix for headings
+func (e *extractor) startMarkdown(n *html.Node) {
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(n.Data[1] - '0')
+		e.builder.WriteString(strings.Repeat("#", level) + " ")
+	}
+}
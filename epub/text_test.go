@@ -0,0 +1,361 @@
+package epub
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// extractHTML returns the text extracted from an XHTML document with body
+func extractHTML(t *testing.T, body string, opts Options) string {
+	t.Helper()
+	return extractDocument(t, xhtml(body), opts)
+}
+
+// extractDocument returns the text extracted from the HTML document doc
+func extractDocument(t *testing.T, doc string, opts Options) string {
+	t.Helper()
+	arc, err := readZip(bytes.NewReader(zipFiles(t, map[string]string{"ch.xhtml": doc})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := extractTextFromHTMLFile(&archiveFile{arc: arc, Name: "ch.xhtml"}, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+func TestExtractPre(t *testing.T) {
+	body := "<p>Before</p><pre>def f(x):\n    if x:\n\n\n        return  x</pre><p>After</p>"
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"text", Options{}, "Before\n\ndef f(x):\n    if x:\n\n\n        return  x\n\nAfter"},
+		{"markdown", Options{Format: FormatMarkdown}, "Before\n\n```\ndef f(x):\n    if x:\n\n\n        return  x\n```\n\nAfter"},
+		{"no blank lines", Options{Cleanup: &Cleanup{CollapseSpaces: true, TrimLines: true}}, "Before\ndef f(x):\n    if x:\n\n\n        return  x\nAfter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractHTML(t, body, tt.opts); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertKeepsPreBlankLines(t *testing.T) {
+	data := testEPUB(t, map[string]string{
+		"ch1.xhtml": xhtml("<pre>line1\n\n\nline4</pre>"),
+	}, "ch1.xhtml")
+	text, err := convertBytes(t, data, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "line1\n\n\nline4\n"; !strings.Contains(text, want) {
+		t.Errorf("text %q does not contain %q", text, want)
+	}
+}
+
+func TestExtractElementSpacing(t *testing.T) {
+	tests := []struct {
+		element string
+		body    string
+		want    string
+	}{
+		{"br", "<p>one<br/>two</p>", "one\ntwo"},
+		{"br twice", "<p>one<br/><br/>two</p>", "one\n\ntwo"},
+		{"hr", "<p>one</p><hr/><p>two</p>", "one\n\n* * *\n\ntwo"},
+		{"hr within text", "<div>one<hr/>two</div>", "one\n\n* * *\n\ntwo"},
+		{"wbr", "<p>one<wbr/>two</p>", "onetwo"},
+		{"img with alt", "<p>one <img src=\"a.png\" alt=\"pic\"/> two</p>", "one [Image: pic] two"},
+		{"img without alt", "<p>one <img src=\"a.png\"/> two</p>", "one two"},
+		{"input", "<p>one <input/> two</p>", "one two"},
+		{"span", "<p>one<span>two</span></p>", "onetwo"},
+		{"p", "<p>one</p><p>two</p>", "one\n\ntwo"},
+		{"div", "<div>one</div><div>two</div>", "one\n\ntwo"},
+		{"h2", "<h2>one</h2><p>two</p>", "one\n\ntwo"},
+		{"section", "<section>one</section>two", "one\ntwo"},
+		{"li", "<ul><li>one</li><li>two</li></ul>", "- one\n- two"},
+		{"td", "<table><tr><td>one</td><td>two</td></tr></table>", "one | two"},
+		{"dd", "<dl><dt>one</dt><dd>two</dd></dl>", "one\n  two"},
+		{"blockquote", "<blockquote>one</blockquote>two", "> one\n\ntwo"},
+		{"figcaption", "<figure><figcaption>one</figcaption></figure>two", "one\n\ntwo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.element, func(t *testing.T) {
+			if got := extractHTML(t, tt.body, Options{AltText: true}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractInlineSpacing(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"link before punctuation", "<p>Hello <a>world</a>!</p>", "Hello world!"},
+		{"link within word", "<p>un<a>break</a>able</p>", "unbreakable"},
+		{"spaced around link", "<p>Hello <a>world</a> again</p>", "Hello world again"},
+		{"nested anchors and spans", "<p><a><span>Hello</span></a> <span><a>world</a></span>!</p>", "Hello world!"},
+		{"adjacent spans", "<p><span>Hel</span><span>lo</span> <span>world</span></p>", "Hello world"},
+		{"comma in span", "<p>word<span> ,</span> next</p>", "word, next"},
+		{"comma after line break", "<p><span>word</span>\n, next</p>", "word, next"},
+		{"punctuation in own element", "<p><em>Stop</em><span>.</span></p>", "Stop."},
+		{"nbsp before punctuation", "<p>Quoi\u00a0<span>?</span></p>", "Quoi\u00a0?"},
+		{"nbsp after element", "<p><span>Oui</span>\u00a0!</p>", "Oui\u00a0!"},
+		{"nbsp in own element", "<p>Bien<span>\u00a0</span>!</p>", "Bien\u00a0!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractHTML(t, tt.body, Options{}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractMarkdownElements(t *testing.T) {
+	tests := []struct {
+		element string
+		body    string
+		want    string
+	}{
+		{"hr", "<p>one</p><hr/><p>two</p>", "one\n\n---\n\ntwo"},
+		{"h2", "<h2>one</h2><p>two</p>", "## one\n\ntwo"},
+		{"td", "<table><tr><td>one</td><td>two</td></tr></table>", "| one | two |"},
+		{"dd", "<dl><dt>one</dt><dd>two</dd></dl>", "one\n: two"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.element, func(t *testing.T) {
+			if got := extractHTML(t, tt.body, Options{Format: FormatMarkdown}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanTextSpaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		want       string
+		wantIndent string // with KeepIndent
+	}{
+		{"tabs and spaces", "one \t two\t\tthree", "one two three", "one two three"},
+		{"nbsp indent", "\u00a0\u00a0\u00a0Indented line", "Indented line", "\u00a0\u00a0\u00a0Indented line"},
+		{"nbsp run within line", "a\u00a0\u00a0\u00a0b", "a\u00a0\u00a0\u00a0b", "a\u00a0\u00a0\u00a0b"},
+		{"trailing nbsp and tab", "end\u00a0 \t", "end", "end"},
+		{"mixed indent", "\u00a0\t\u00a0word", "word", "\u00a0 \u00a0word"},
+		{
+			"french punctuation",
+			"Quoi\u00a0? Oui\u00a0! «\u00a0Bien\u00a0» dit-il\u00a0: non\u00a0;",
+			"Quoi\u00a0? Oui\u00a0! «\u00a0Bien\u00a0» dit-il\u00a0: non\u00a0;",
+			"Quoi\u00a0? Oui\u00a0! «\u00a0Bien\u00a0» dit-il\u00a0: non\u00a0;",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanText(tt.text, Options{}); got != tt.want {
+				t.Errorf("cleanText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if got := cleanText(tt.text, Options{KeepIndent: true}); got != tt.wantIndent {
+				t.Errorf("cleanText(%q) with KeepIndent = %q, want %q", tt.text, got, tt.wantIndent)
+			}
+		})
+	}
+}
+
+func TestExtractFrenchTypography(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		want       string
+		wantIndent string // with KeepIndent
+	}{
+		{"nbsp before punctuation", "<p>Quoi\u00a0? <em>Oui</em>\u00a0!</p>", "Quoi\u00a0? Oui\u00a0!", "Quoi\u00a0? Oui\u00a0!"},
+		{"guillemets around inline element", "<p>«\u00a0<span>Bien</span>\u00a0»</p>", "«\u00a0Bien\u00a0»", "«\u00a0Bien\u00a0»"},
+		{"nbsp indent with tabs", "<p>\u00a0\u00a0Indent\tand \t tab</p>", "Indent and tab", "\u00a0\u00a0Indent and tab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractHTML(t, tt.body, Options{}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if got := extractHTML(t, tt.body, Options{KeepIndent: true}); got != tt.wantIndent {
+				t.Errorf("with KeepIndent got %q, want %q", got, tt.wantIndent)
+			}
+		})
+	}
+}
+
+func TestCleanupOptions(t *testing.T) {
+	body := "<p>one  \t two   </p><div>line\nbreak</div><p>a</p><br/><br/><br/><br/><p>b</p>"
+	tests := []struct {
+		name    string
+		cleanup Cleanup
+		want    string
+	}{
+		{"default", DefaultCleanup(), "one two\n\nline break\n\na\n\nb"},
+		{"collapse spaces only", Cleanup{CollapseSpaces: true, MaxBlankLines: 1}, "one two \n\nline break \n\na \n\nb "},
+		{"trim lines only", Cleanup{TrimLines: true, MaxBlankLines: 1}, "one  \t two\n\nline break\n\na\n\nb"},
+		{"no blank lines", Cleanup{CollapseSpaces: true, TrimLines: true}, "one two\nline break\na\nb"},
+		{"keep all blank lines", Cleanup{CollapseSpaces: true, TrimLines: true, MaxBlankLines: -1}, "one two\n\nline break\n\na\n\n\n\n\n\nb"},
+		{"keep newlines", Cleanup{CollapseSpaces: true, TrimLines: true, MaxBlankLines: 1, KeepNewlines: true}, "one two\n\nline\nbreak\n\na\n\nb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractHTML(t, body, Options{Cleanup: &tt.cleanup}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractEmphasis(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"em", "<p>one <em>two</em> three</p>", "one *two* three"},
+		{"strong", "<p>one <strong>two</strong> three</p>", "one **two** three"},
+		{"inner spaces", "<p>one<em> two </em>three</p>", "one *two* three"},
+		{"attached", "<p><em>one</em>, two</p>", "*one*, two"},
+		{"nested", "<p><strong>one <em>two</em></strong> three</p>", "**one *two*** three"},
+		{"repeated", "<p><em>one <i>two</i></em></p>", "*one two*"},
+		{"empty", "<p>one <em> </em>two</p>", "one two"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractHTML(t, tt.body, Options{Format: FormatMarkdown}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractMalformedFallback(t *testing.T) {
+	paragraph := strings.Repeat("Some words of the chapter. ", 20)
+	doc := "<html><head><title>Unclosed\n</head><body><p>" + paragraph + "</p></body></html>"
+	if got := extractDocument(t, doc, Options{}); !strings.Contains(got, "Some words of the chapter.") {
+		t.Errorf("text %q was not recovered from the truncated file", got)
+	}
+}
+
+func TestExtractMalformedFallbackTranscodesOnce(t *testing.T) {
+	paragraph := strings.Repeat("Some words of the chapter. ", 20)
+	doc := `<?xml version="1.0" encoding="x-made-up"?>` +
+		"<html><head><title>Unclosed\n</head><body><p>" + paragraph + "</p></body></html>"
+	var warnings bytes.Buffer
+	got := extractDocument(t, doc, Options{Warnings: &warnings})
+	if !strings.Contains(got, "Some words of the chapter.") {
+		t.Errorf("text %q was not recovered from the truncated file", got)
+	}
+	if n := strings.Count(warnings.String(), "unsupported character encoding"); n != 1 {
+		t.Errorf("encoding warned about %d times, want once: %q", n, warnings.String())
+	}
+}
+
+func TestExtractFilteredNoFallback(t *testing.T) {
+	filler := strings.Repeat("Left out on purpose. ", 40)
+	tests := []struct {
+		name string
+		body string
+		opts Options
+	}{
+		{"hidden", `<div hidden="">` + filler + `</div>`, Options{}},
+		{"footnote refs", `<a epub:type="noteref" href="#n1">` + filler + `</a>`, Options{StripFootnoteRefs: true}},
+		{"front matter", `<section epub:type="frontmatter">` + filler + `</section>`, Options{OnlyBodymatter: true}},
+		{"svg", `<svg><title>` + filler + `</title></svg>`, Options{}},
+		{"ruby text", `<ruby>漢<rt>` + filler + `</rt></ruby>`, Options{}},
+		{"in blockquote", `<blockquote><div hidden="">` + filler + `</div></blockquote>`, Options{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractHTML(t, "<p>Kept.</p>"+tt.body, tt.opts)
+			if strings.Contains(got, "Left out") {
+				t.Errorf("filtered text came back: %q", got)
+			}
+			if !strings.Contains(got, "Kept.") {
+				t.Errorf("text %q is missing the kept paragraph", got)
+			}
+		})
+	}
+}
+
+func TestExtractLists(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"items", "<ul><li>alpha</li><li>beta</li></ul>", "- alpha\n- beta"},
+		{"ordered", "<ol><li>one</li><li>two</li></ol>", "1. one\n2. two"},
+		{"paragraph items", "<ul><li><p>alpha</p></li><li><p>beta</p></li></ul>", "- alpha\n- beta"},
+		{"nested blocks", "<ol><li><div><p>one</p></div></li><li><p>two</p></li></ol>", "1. one\n2. two"},
+		{"nested list", "<ul><li>a<ul><li>b</li><li>c</li></ul></li><li>d</li></ul>", "- a\n  - b\n  - c\n- d"},
+		{"nested paragraph list", "<ul><li><p>a</p><ol><li><p>b</p></li></ol></li><li><p>d</p></li></ul>", "- a\n  1. b\n- d"},
+		{"empty item", "<ul><li><p></p></li><li>b</li></ul>", "-\n- b"},
+		{"between paragraphs", "<p>before</p><ul><li><p>a</p></li><li><p>b</p></li></ul><p>after</p>", "before\n\n- a\n- b\n\nafter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractHTML(t, tt.body, Options{}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if got := extractHTML(t, tt.body, Options{Format: FormatMarkdown}); got != tt.want {
+				t.Errorf("markdown got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractTables(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		want     string
+		markdown string
+	}{
+		{
+			"cells",
+			"<table><tr><th>H1</th><th>H2</th></tr><tr><td>a</td><td>b</td></tr></table>",
+			"H1 | H2\na | b",
+			"| H1 | H2 |\n| --- | --- |\n| a | b |",
+		},
+		{
+			"paragraph cells",
+			"<table><tr><th><p>H1</p></th><th><p>H2</p></th></tr><tr><td><p>a</p></td><td><div>b</div><p>c</p></td></tr></table>",
+			"H1 | H2\na | b c",
+			"| H1 | H2 |\n| --- | --- |\n| a | b c |",
+		},
+		{
+			"line breaks and lists in cells",
+			"<table><tr><td>one<br/>two</td><td><ul><li>x</li><li>y</li></ul></td></tr></table>",
+			"one two | - x - y",
+			"| one two | - x - y |",
+		},
+		{
+			"between paragraphs",
+			"<p>before</p><table><tr><td><p>a</p></td><td><p>b</p></td></tr></table><p>after</p>",
+			"before\n\na | b\n\nafter",
+			"before\n\n| a | b |\n\nafter",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractHTML(t, tt.body, Options{}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if got := extractHTML(t, tt.body, Options{Format: FormatMarkdown}); got != tt.markdown {
+				t.Errorf("markdown got %q, want %q", got, tt.markdown)
+			}
+		})
+	}
+}
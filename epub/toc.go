@@ -0,0 +1,326 @@
+package epub
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// TOCEntry is a single entry of a table of contents. Href is relative
+// to the package document, like manifest hrefs; Level is the nesting
+// depth starting at 0.
+type TOCEntry struct {
+	Title string `json:"title"`
+	Href  string `json:"href"`
+	Level int    `json:"level"`
+}
+
+// NCX models the EPUB2 table of contents file
+type NCX struct {
+	XMLName xml.Name `xml:"ncx"`
+	NavMap  NavMap   `xml:"navMap"`
+}
+
+type NavMap struct {
+	NavPoints []NavPoint `xml:"navPoint"`
+}
+
+type NavPoint struct {
+	NavLabel  NavLabel   `xml:"navLabel"`
+	Content   Content    `xml:"content"`
+	NavPoints []NavPoint `xml:"navPoint"`
+}
+
+type NavLabel struct {
+	Text string `xml:"text"`
+}
+
+type Content struct {
+	Src string `xml:"src,attr"`
+}
+
+// readTOC reads the table of contents from the EPUB3 navigation document
+// or the EPUB2 NCX file, trying first the one the package version calls
+// for and warning when only the other is found
+func readTOC(arc archive, pkg *Package, baseDir string, opts Options) ([]TOCEntry, error) {
+	if pkg.IsEPUB2() {
+		entries, ncxErr := readNCXTOC(arc, pkg, baseDir, opts)
+		if ncxErr == nil {
+			return entries, nil
+		}
+		entries, navErr := readNavTOC(arc, pkg, baseDir, opts)
+		if navErr != nil {
+			return nil, fmt.Errorf("no table of contents: %v; %v", ncxErr, navErr)
+		}
+		opts.warnf("package declares EPUB version %s but has no usable NCX (%v); using its EPUB 3 navigation document", pkg.Version, ncxErr)
+		return entries, nil
+	}
+
+	entries, navErr := readNavTOC(arc, pkg, baseDir, opts)
+	if navErr == nil {
+		return entries, nil
+	}
+
+	entries, ncxErr := readNCXTOC(arc, pkg, baseDir, opts)
+	if ncxErr != nil {
+		return nil, fmt.Errorf("no table of contents: %v; %v", navErr, ncxErr)
+	}
+	if pkg.Version != "" {
+		opts.warnf("package declares EPUB version %s but has no usable navigation document (%v); using its EPUB 2 NCX", pkg.Version, navErr)
+	}
+	return entries, nil
+}
+
+// readNavTOC finds the EPUB3 navigation document through the manifest
+// "nav" property and parses its table of contents
+func readNavTOC(arc archive, pkg *Package, baseDir string, opts Options) ([]TOCEntry, error) {
+	var navItem *Item
+	for i, item := range pkg.Manifest.Items {
+		if item.HasProperty("nav") {
+			navItem = &pkg.Manifest.Items[i]
+			break
+		}
+	}
+	if navItem == nil {
+		return nil, fmt.Errorf("no navigation document found in manifest")
+	}
+
+	navPath := resolveHref(baseDir, navItem.Href)
+	navFile := findFile(arc, navPath)
+	if navFile == nil {
+		return nil, fmt.Errorf("navigation document not found: %s", navPath)
+	}
+
+	entries, err := parseNav(navFile, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nav hrefs are relative to the nav document; rebase them onto the
+	// package document so they compare with manifest hrefs
+	navDir := path.Dir(navItem.Href)
+	for i := range entries {
+		entries[i].Href = rebaseHref(navDir, entries[i].Href)
+	}
+
+	return entries, nil
+}
+
+// readNCXTOC finds the NCX file through the spine's toc attribute, or
+// failing that its media type, and parses its navigation map
+func readNCXTOC(arc archive, pkg *Package, baseDir string, opts Options) ([]TOCEntry, error) {
+	var ncxItem *Item
+	for i, item := range pkg.Manifest.Items {
+		if pkg.Spine.TOC != "" && item.ID == pkg.Spine.TOC {
+			ncxItem = &pkg.Manifest.Items[i]
+			break
+		}
+		if ncxItem == nil && item.MediaType == "application/x-dtbncx+xml" {
+			ncxItem = &pkg.Manifest.Items[i]
+		}
+	}
+	if ncxItem == nil {
+		return nil, fmt.Errorf("no NCX file found in manifest")
+	}
+
+	ncxPath := resolveHref(baseDir, ncxItem.Href)
+	ncxFile := findFile(arc, ncxPath)
+	if ncxFile == nil {
+		return nil, fmt.Errorf("NCX file not found: %s", ncxPath)
+	}
+
+	ncx, err := parseNCX(ncxFile, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// NCX sources are relative to the NCX file; rebase them onto the
+	// package document so they compare with manifest hrefs
+	var entries []TOCEntry
+	collectNavPoints(ncx.NavMap.NavPoints, 0, path.Dir(ncxItem.Href), &entries)
+	return entries, nil
+}
+
+func parseNCX(ncxFile *archiveFile, opts Options) (*NCX, error) {
+	reader, err := ncxFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open NCX file: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read NCX file: %w", err)
+	}
+
+	var ncx NCX
+	err = unmarshalXML(data, &ncx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse NCX file: %w", err)
+	}
+
+	return &ncx, nil
+}
+
+// collectNavPoints flattens nested navPoints into ordered entries
+func collectNavPoints(points []NavPoint, level int, ncxDir string, entries *[]TOCEntry) {
+	for _, point := range points {
+		title := strings.Join(strings.Fields(point.NavLabel.Text), " ")
+		if title != "" {
+			*entries = append(*entries, TOCEntry{
+				Title: title,
+				Href:  rebaseHref(ncxDir, point.Content.Src),
+				Level: level,
+			})
+		}
+		collectNavPoints(point.NavPoints, level+1, ncxDir, entries)
+	}
+}
+
+// rebaseHref resolves a relative href against dir, leaving absolute
+// URLs untouched
+func rebaseHref(dir, href string) string {
+	if href == "" || strings.Contains(href, ":") {
+		return href
+	}
+	return path.Join(dir, href)
+}
+
+// parseNav extracts the entries of the epub:type="toc" nav element
+func parseNav(navFile *archiveFile, opts Options) ([]TOCEntry, error) {
+	reader, err := navFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open navigation document: %w", err)
+	}
+	defer reader.Close()
+
+	doc, err := parseHTML(reader, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse navigation document: %w", err)
+	}
+
+	// Prefer the nav marked as the TOC, falling back to the first nav
+	var tocNav, firstNav *html.Node
+	var findNav func(n *html.Node)
+	findNav = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "nav" {
+			if firstNav == nil {
+				firstNav = n
+			}
+			if tocNav == nil && hasEpubType(n, "toc") {
+				tocNav = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			findNav(c)
+		}
+	}
+	findNav(doc)
+	if tocNav == nil {
+		tocNav = firstNav
+	}
+	if tocNav == nil {
+		return nil, fmt.Errorf("no nav element found in navigation document")
+	}
+
+	var entries []TOCEntry
+	collectNavEntries(tocNav, -1, &entries)
+	return entries, nil
+}
+
+// collectNavEntries walks nested <ol> lists collecting the links of each
+// <li>, tracking the list depth as the entry level
+func collectNavEntries(n *html.Node, level int, entries *[]TOCEntry) {
+	if n.Type == html.ElementNode {
+		switch n.Data {
+		case "ol", "ul":
+			level++
+		case "a":
+			title := strings.Join(strings.Fields(nodeText(n)), " ")
+			if title != "" {
+				*entries = append(*entries, TOCEntry{
+					Title: title,
+					Href:  attr(n, "href"),
+					Level: max(level, 0),
+				})
+			}
+			return
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		collectNavEntries(c, level, entries)
+	}
+}
+
+// tocTitle returns the title of the first entry pointing at href,
+// ignoring any fragment identifier and percent-encoding
+func tocTitle(entries []TOCEntry, href string) string {
+	target := path.Clean(hrefPath(href))
+	for _, entry := range entries {
+		entryPath := hrefPath(entry.Href)
+		if entryPath != "" && path.Clean(entryPath) == target {
+			return entry.Title
+		}
+	}
+	return ""
+}
+
+// formatTOC renders the table of contents as an indented list
+func formatTOC(entries []TOCEntry) string {
+	var toc strings.Builder
+	toc.WriteString("Contents\n")
+	for _, entry := range entries {
+		toc.WriteString(strings.Repeat("  ", entry.Level+1))
+		toc.WriteString(entry.Title)
+		toc.WriteString("\n")
+	}
+	return toc.String()
+}
+
+// nodeText returns the concatenated text of n and its descendants
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(nodeText(c))
+	}
+	return text.String()
+}
+
+// attr returns the value of the named attribute, or "" if absent
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// epubType returns the epub:type attribute of n
+func epubType(n *html.Node) string {
+	for _, a := range n.Attr {
+		if a.Key == "epub:type" || (a.Namespace == "epub" && a.Key == "type") {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// hasEpubType reports whether the space-separated epub:type attribute
+// of n includes value
+func hasEpubType(n *html.Node, value string) bool {
+	for _, t := range strings.Fields(epubType(n)) {
+		if t == value {
+			return true
+		}
+	}
+	return false
+}
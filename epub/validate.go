@@ -0,0 +1,163 @@
+package epub
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// epubMimetype is the required content of an EPUB's mimetype file
+const epubMimetype = "application/epub+zip"
+
+// Validate reads an entire EPUB from r and checks its structure without
+// converting it, returning a description of each problem found. The error
+// is only set when the input cannot be read as an archive at all.
+func Validate(r io.Reader, opts Options) ([]string, error) {
+	arc, err := readZip(r)
+	if err != nil {
+		return nil, err
+	}
+	return validate(arc, opts), nil
+}
+
+// ValidateFile opens the EPUB at path and checks its structure without
+// converting it, returning a description of each problem found.
+func ValidateFile(path string, opts Options) ([]string, error) {
+	arc, closeArc, err := openPath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArc()
+
+	return validate(arc, opts), nil
+}
+
+// validate checks that the container, package document, spine, and
+// manifest of the EPUB are consistent with each other and the archive,
+// and that metadata.xml, if there is one, is well formed
+func validate(arc archive, opts Options) []string {
+	arc = limitArchive(arc, opts.MaxUncompressedBytes)
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// The mimetype file identifies the archive as an EPUB
+	if mimetypeFile := findFile(arc, "mimetype"); mimetypeFile == nil {
+		report("mimetype file not found")
+	} else if mimetype, err := readAll(mimetypeFile); err != nil {
+		report("failed to read mimetype file: %v", err)
+	} else if strings.TrimSpace(string(mimetype)) != epubMimetype {
+		report("mimetype file contains %q, not %q", mimetype, epubMimetype)
+	}
+
+	// Multiple-rendition books describe the publication as a whole in
+	// metadata.xml, which is optional
+	if metadataFile := findFile(arc, "META-INF/metadata.xml"); metadataFile != nil {
+		if err := checkPublicationMetadata(metadataFile, opts); err != nil {
+			report("META-INF/metadata.xml: %v", err)
+		}
+	}
+
+	containerFile := findFile(arc, "META-INF/container.xml")
+	if containerFile == nil {
+		report("%v", ErrNoContainer)
+		return problems
+	}
+	container, err := parseContainer(containerFile, opts)
+	if err != nil {
+		report("%v", err)
+		return problems
+	}
+	rootFile, err := selectRootFile(container.RootFiles.RootFile, opts)
+	if err != nil {
+		report("%v", err)
+		return problems
+	}
+
+	opfFile := findFile(arc, rootFile.FullPath)
+	if opfFile == nil {
+		report("%v at path: %s", ErrOPFNotFound, rootFile.FullPath)
+		return problems
+	}
+	pkg, err := parsePackage(opfFile, opts)
+	if err != nil {
+		report("%v", err)
+		return problems
+	}
+	baseDir := path.Dir(zipPath(rootFile.FullPath))
+
+	// Every manifest item must be unique and present in the archive
+	ids := make(map[string]bool)
+	for _, item := range pkg.Manifest.Items {
+		if item.ID == "" {
+			report("manifest item %s has no id", item.Href)
+		} else if ids[item.ID] {
+			report("manifest id %q is used more than once", item.ID)
+		}
+		ids[item.ID] = true
+
+		// Remote resources are not part of the archive
+		if item.Href == "" {
+			report("manifest item %q has no href", item.ID)
+		} else if !strings.Contains(item.Href, ":") {
+			if contentPath := resolveHref(baseDir, item.Href); findFile(arc, contentPath) == nil {
+				report("manifest item %q: file not found: %s", item.ID, contentPath)
+			}
+		}
+	}
+
+	// Every spine entry must name a manifest item
+	if len(pkg.Spine.ItemRefs) == 0 {
+		report("spine is empty")
+	}
+	for i, itemRef := range pkg.Spine.ItemRefs {
+		if !ids[itemRef.IDRef] {
+			report("spine item %d: itemref %q does not match any manifest item", i+1, itemRef.IDRef)
+		}
+	}
+
+	return problems
+}
+
+// publicationMetadata is the part of META-INF/metadata.xml that
+// validation checks
+type publicationMetadata struct {
+	XMLName     xml.Name
+	Identifiers []string `xml:"identifier"`
+}
+
+// checkPublicationMetadata checks that metadata.xml is a <metadata>
+// document naming the publication's identifier, as the EPUB
+// Multiple-Rendition spec requires
+func checkPublicationMetadata(f *archiveFile, opts Options) error {
+	data, err := readAll(f)
+	if err != nil {
+		return fmt.Errorf("failed to read: %w", err)
+	}
+	var metadata publicationMetadata
+	if err := unmarshalXML(data, &metadata, opts); err != nil {
+		return fmt.Errorf("failed to parse: %w", err)
+	}
+	if metadata.XMLName.Local != "metadata" {
+		return fmt.Errorf("root element is <%s>, not <metadata>", metadata.XMLName.Local)
+	}
+	for _, identifier := range metadata.Identifiers {
+		if strings.TrimSpace(identifier) != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("no dc:identifier")
+}
+
+// readAll returns the contents of an archive file
+func readAll(f *archiveFile) ([]byte, error) {
+	reader, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,51 @@
+package epub
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidatePublicationMetadata(t *testing.T) {
+	files := map[string]string{"ch1.xhtml": xhtml("<p>Text</p>")}
+	with := func(metadata string) map[string]string {
+		withMetadata := maps.Clone(files)
+		withMetadata["META-INF/metadata.xml"] = metadata
+		return withMetadata
+	}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string // problem reported, or "" for none
+	}{
+		{"no metadata.xml", files, ""},
+		{"valid", with(`<?xml version="1.0"?>
+<metadata xmlns="http://www.idpf.org/2013/metadata" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <dc:identifier>urn:uuid:1234</dc:identifier>
+  <meta property="dcterms:modified">2024-01-01T00:00:00Z</meta>
+</metadata>`), ""},
+		{"malformed", with(`<metadata><dc:identifier>urn:uuid:1234`), "META-INF/metadata.xml: failed to parse"},
+		{"wrong root", with(`<package><identifier>urn:uuid:1234</identifier></package>`), "META-INF/metadata.xml: root element is <package>"},
+		{"no identifier", with(`<metadata xmlns="http://www.idpf.org/2013/metadata"><meta property="dcterms:modified">2024-01-01T00:00:00Z</meta></metadata>`), "META-INF/metadata.xml: no dc:identifier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := Validate(bytes.NewReader(testEPUB(t, tt.files, "ch1.xhtml")), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if len(problems) > 0 {
+					t.Errorf("unexpected problems: %q", problems)
+				}
+				return
+			}
+			if !slices.ContainsFunc(problems, func(p string) bool { return strings.HasPrefix(p, tt.want) }) {
+				t.Errorf("problems %q do not include %q", problems, tt.want)
+			}
+		})
+	}
+}
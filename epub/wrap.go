@@ -0,0 +1,68 @@
+package epub
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// listMarker matches the bullet or number starting a list item
+var listMarker = regexp.MustCompile(`^(- |\d+\. )`)
+
+// wrapText breaks each line of text between words so that no line is
+// longer than width, unless a single word is. Continuation lines repeat
+// a line's quote markers and indentation and are aligned with the text
+// of list items. Preformatted lines and table rows, still marked as
+// cleanText leaves them, are kept whole, as are Markdown headings.
+func wrapText(text string, width int, markdown bool) string {
+	lines := strings.Split(text, "\n")
+	var wrapped []string
+	for _, line := range lines {
+		content := strings.TrimLeft(line, " >")
+		if strings.HasPrefix(content, preMark) || strings.HasPrefix(content, rowMark) || markdown && strings.HasPrefix(content, "#") {
+			wrapped = append(wrapped, line)
+			continue
+		}
+		wrapped = append(wrapped, wrapLine(line, width)...)
+	}
+	return strings.Join(wrapped, "\n")
+}
+
+func wrapLine(line string, width int) []string {
+	if utf8.RuneCountInString(line) <= width {
+		return []string{line}
+	}
+
+	// Split off the prefix that continuation lines must repeat
+	content := strings.TrimLeft(line, " >")
+	prefix := line[:len(line)-len(content)]
+	first := prefix
+	indent := prefix
+	if marker := listMarker.FindString(content); marker != "" {
+		first += marker
+		indent += strings.Repeat(" ", len(marker))
+		content = content[len(marker):]
+	}
+
+	var lines []string
+	current := first
+	length := utf8.RuneCountInString(current)
+	empty := true
+	for _, word := range strings.Fields(content) {
+		wordLength := utf8.RuneCountInString(word)
+		if !empty && length+1+wordLength > width {
+			lines = append(lines, current)
+			current = indent
+			length = utf8.RuneCountInString(indent)
+			empty = true
+		}
+		if !empty {
+			current += " "
+			length++
+		}
+		current += word
+		length += wordLength
+		empty = false
+	}
+	return append(lines, current)
+}
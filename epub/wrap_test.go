@@ -0,0 +1,66 @@
+package epub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		markdown bool
+		want     string
+	}{
+		{"paragraph", "one two three four five", false, "one two three\nfour five"},
+		{"short line", "one two", false, "one two"},
+		{"long word", "unbreakablewordhere", false, "unbreakablewordhere"},
+		{"quote", "> one two three four five", false, "> one two\n> three four\n> five"},
+		{"list item", "- one two three four", false, "- one two\n  three four"},
+		{"preformatted", preMark + "x = f(a,    b) + g(c, d)", false, preMark + "x = f(a,    b) + g(c, d)"},
+		{"table row", rowMark + "one | two | three | four", false, rowMark + "one | two | three | four"},
+		{"markdown heading", "# one two three four five", true, "# one two three four five"},
+		{"heading in text", "# one two three four", false, "# one two\nthree four"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wrapText(tt.text, 14, tt.markdown); got != tt.want {
+				t.Errorf("wrapText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertWrapKeepsCode(t *testing.T) {
+	code := "    return some_function(x,    y) + another_call(z)"
+	data := testEPUB(t, map[string]string{
+		"ch1.xhtml": xhtml("<p>A paragraph long enough that it has to be wrapped.</p>" +
+			"<pre>def f(x):\n" + code + "</pre>" +
+			"<table><tr><td>first cell</td><td>second cell that runs past the width</td></tr></table>"),
+	}, "ch1.xhtml")
+
+	for _, format := range []string{FormatText, FormatMarkdown} {
+		text, err := convertBytes(t, data, Options{Wrap: 30, Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			"A paragraph long enough that\nit has to be wrapped.",
+			code + "\n",
+			"first cell | second cell that runs past the width",
+		} {
+			if !strings.Contains(text, want) {
+				t.Errorf("%s output %q does not contain %q", format, text, want)
+			}
+		}
+	}
+}
+
+func TestExtractWrapMarkdownTable(t *testing.T) {
+	body := "<table><tr><th>First</th><th>Second</th><th>Third</th></tr>" +
+		"<tr><td>alpha beta</td><td>gamma delta</td><td>epsilon</td></tr></table>"
+	want := "| First | Second | Third |\n| --- | --- | --- |\n| alpha beta | gamma delta | epsilon |"
+	if got := extractHTML(t, body, Options{Format: FormatMarkdown, Wrap: 15}); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,39 @@
+package epub
+
+import "io"
+
+// newlineWriter rewrites every line ending written through it
+type newlineWriter struct {
+	w       io.Writer
+	newline []byte
+	cr      bool // the last byte written was a '\r'
+}
+
+// NewlineWriter returns a writer that replaces each line ending written
+// through it, whether "\n", "\r\n" or a lone "\r", with newline. A
+// "\r\n" split across two writes still counts as a single line ending.
+func NewlineWriter(w io.Writer, newline string) io.Writer {
+	return &newlineWriter{w: w, newline: []byte(newline)}
+}
+
+func (n *newlineWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, c := range p {
+		switch c {
+		case '\r':
+			out = append(out, n.newline...)
+		case '\n':
+			if !n.cr {
+				out = append(out, n.newline...)
+			}
+		default:
+			out = append(out, c)
+		}
+		n.cr = c == '\r'
+	}
+
+	if _, err := n.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
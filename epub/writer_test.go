@@ -0,0 +1,81 @@
+package epub
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNewlineWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		newline string
+		writes  []string
+		want    string
+	}{
+		{"LF", "\n", []string{"one\r\ntwo\rthree\nfour"}, "one\ntwo\nthree\nfour"},
+		{"CRLF", "\r\n", []string{"one\r\ntwo\rthree\nfour"}, "one\r\ntwo\r\nthree\r\nfour"},
+		{"blank lines", "\r\n", []string{"one\n\n\r\n\r\rtwo"}, "one\r\n\r\n\r\n\r\n\r\ntwo"},
+		{"CRLF split across writes", "\r\n", []string{"one\r", "\ntwo\r", "\n"}, "one\r\ntwo\r\n"},
+		{"LF split across writes", "\n", []string{"one\r", "\ntwo"}, "one\ntwo"},
+		{"CR then text", "\n", []string{"one\r", "two"}, "one\ntwo"},
+		{"BOM kept as written", "\r\n", []string{"\ufeffone\n"}, "\ufeffone\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewlineWriter(&buf, tt.newline)
+			for _, s := range tt.writes {
+				n, err := w.Write([]byte(s))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != len(s) {
+					t.Errorf("Write(%q) = %d, want %d", s, n, len(s))
+				}
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextLineEndings(t *testing.T) {
+	tests := []struct {
+		name    string
+		newline string
+		want    string
+	}{
+		{"LF", "\n", "one\ntwo\nthree\n\nfour"},
+		{"CRLF", "\r\n", "one\r\ntwo\r\nthree\r\n\r\nfour"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := &textWriter{w: NewlineWriter(&buf, tt.newline)}
+			if err := tw.write("\ufeffone\r\ntwo\rthree\n\n\ufefffour"); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertNoBOM(t *testing.T) {
+	data := testEPUB(t, map[string]string{
+		"ch1.xhtml": "\ufeff" + strings.ReplaceAll(xhtml("<p>one</p>\r\n<p>two\ufeff</p>"), "\n", "\r\n"),
+	}, "ch1.xhtml")
+	text, err := convertBytes(t, data, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(text, "\r\ufeff") {
+		t.Errorf("text %q has a carriage return or byte order mark", text)
+	}
+	if want := "one\n\ntwo\n"; !strings.Contains(text, want) {
+		t.Errorf("text %q does not contain %q", text, want)
+	}
+}
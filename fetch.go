@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultTimeout bounds the time taken to download a book
+const defaultTimeout = time.Minute
+
+// isURL reports whether input names a book to download rather than a file
+func isURL(input string) bool {
+	return strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://")
+}
+
+// urlBaseName returns the last element of a URL's path, ignoring any
+// query or fragment
+func urlBaseName(input string) string {
+	u, err := url.Parse(input)
+	if err != nil || path.Base(u.Path) == "/" || path.Base(u.Path) == "." {
+		return "book.epub"
+	}
+	return path.Base(u.Path)
+}
+
+// localPath returns a file path to read input from. URLs are downloaded
+// into a temporary file, which the returned function removes; other
+// inputs are returned as they are.
+func localPath(input string, s *settings) (string, func(), error) {
+	if !isURL(input) {
+		return input, func() {}, nil
+	}
+
+	// ZIP needs random access, so the whole book is downloaded first
+	client := &http.Client{Timeout: s.timeout}
+	req, err := http.NewRequest(http.MethodGet, input, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download %s: %w", input, err)
+	}
+	req.Header.Set("User-Agent", s.userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download %s: %w", input, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("failed to download %s: %s", input, resp.Status)
+	}
+
+	epubPath, cleanup, err := tempCopy(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download %s: %w", input, err)
+	}
+	return epubPath, cleanup, nil
+}
+
+// withInput calls fn with a file path to read inputFile from: a local
+// copy of stdin when inputFile is "-", and otherwise the path localPath
+// returns. Any temporary copy is removed once fn returns.
+func withInput(inputFile string, s *settings, fn func(path string) error) error {
+	var epubPath string
+	var cleanup func()
+	var err error
+	if inputFile == "-" {
+		epubPath, cleanup, err = tempCopy(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read EPUB from stdin: %w", err)
+		}
+	} else {
+		epubPath, cleanup, err = localPath(inputFile, s)
+		if err != nil {
+			return err
+		}
+	}
+	defer cleanup()
+	return fn(epubPath)
+}
+
+// tempCopy copies r into a temporary file, returning its path and a
+// function that removes it
+func tempCopy(r io.Reader) (string, func(), error) {
+	file, err := os.CreateTemp("", "epub2text-*.epub")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	cleanup := func() { os.Remove(file.Name()) }
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return file.Name(), cleanup, nil
+}
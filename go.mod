@@ -3,3 +3,5 @@ module github.com/nealhardesty/epub2text
 go 1.24.0
 
 require golang.org/x/net v0.37.0
+
+require golang.org/x/text v0.23.0
@@ -2,306 +2,723 @@ package main
 
 import (
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
-	"golang.org/x/net/html"
+	"github.com/nealhardesty/epub2text/epub"
 )
 
-// Package metadata structure
-type Package struct {
-	XMLName  xml.Name `xml:"package"`
-	Manifest Manifest `xml:"manifest"`
-	Spine    Spine    `xml:"spine"`
-}
-
-type Manifest struct {
-	Items []Item `xml:"item"`
-}
-
-type Item struct {
-	ID        string `xml:"id,attr"`
-	Href      string `xml:"href,attr"`
-	MediaType string `xml:"media-type,attr"`
-}
-
-type Spine struct {
-	ItemRefs []ItemRef `xml:"itemref"`
-}
-
-type ItemRef struct {
-	IDRef string `xml:"idref,attr"`
-}
-
-// Container metadata structure
-type Container struct {
-	XMLName   xml.Name  `xml:"container"`
-	RootFiles RootFiles `xml:"rootfiles"`
-}
-
-type RootFiles struct {
-	RootFile []RootFile `xml:"rootfile"`
-}
-
-type RootFile struct {
-	FullPath  string `xml:"full-path,attr"`
-	MediaType string `xml:"media-type,attr"`
-}
-
 func main() {
 	// Define command line flags
-	inputFile := flag.String("input", "", "Path to EPUB file (required)")
-	outputFile := flag.String("output", "", "Path to output text file (default: derived from input filename)")
+	inputFile := flag.String("input", "", "Path or http(s) URL of an EPUB file, an unpacked EPUB directory, or - for stdin (required; more files may follow as arguments)")
+	outputFile := flag.String("output", "", "Path to output text file, or - for stdout (default: derived from input filename)")
+	overwrite := flag.Bool("overwrite", false, "Replace output files that already exist instead of failing")
+	split := flag.String("split", "", "Write each chapter to its own numbered file in this directory instead of a single output")
+	outputTemplate := flag.String("output-template", "", "Derive each output path from the input, e.g. \"{dir}/txt/{name}.txt\" ({dir}, {name}, and {ext} are replaced)")
+	metadata := flag.Bool("metadata", false, "Include a title/author/language header at the top of the output")
+	separator := flag.String("separator", "----", "Line written between chapters (empty for a blank line only)")
+	toc := flag.Bool("toc", false, "Include the table of contents at the top of the output")
+	chapterTitles := flag.Bool("chapter-titles", false, "Write each chapter's table of contents title above its text")
+	numberChapters := flag.Bool("number-chapters", false, "Write \"Chapter N\" above each chapter's text, before its title when -chapter-titles is set")
+	altText := flag.Bool("alt-text", false, "Include image alt text as [Image: ...]")
+	captionPrefix := flag.String("caption-prefix", "", "Text written before each figure caption, such as \"Figure: \"")
+	pageMarkers := flag.Bool("page-markers", false, "Include [Page N] markers from epub:type=\"pagebreak\" elements")
+	includeNonLinear := flag.Bool("include-nonlinear", false, "Include spine items marked linear=\"no\" such as popup footnotes")
+	includeHidden := flag.Bool("include-hidden", false, "Include content marked hidden or aria-hidden=\"true\"")
+	onlyBodymatter := flag.Bool("only-bodymatter", false, "Skip content marked epub:type=\"frontmatter\" or \"backmatter\"")
+	splitAnchors := flag.Bool("split-anchors", false, "Start a new chapter at each anchor the table of contents points to within a content file")
+	dedup := flag.Bool("dedup", false, "Extract a content file only once when the spine lists it several times")
+	rendition := flag.Int("rendition", 0, "Read the Nth package document when container.xml lists several (default: the first)")
+	force := flag.Bool("force", false, "Extract all HTML files in name order when the OPF is missing or unreadable")
+	chapter := flag.Int("chapter", 0, "Extract only the chapter at this 1-based spine position")
+	chapterHref := flag.String("chapter-href", "", "Extract only the chapter with this href")
+	from := flag.Int("from", 0, "Extract the chapters from this 1-based spine position on")
+	to := flag.Int("to", 0, "Extract the chapters up to and including this 1-based spine position")
+	contentTypes := flag.String("content-types", "", "Comma-separated media types to extract besides HTML and DTBook, such as text/plain")
+	startAt := flag.String("start-at", "", "Start extraction at the page the EPUB 2 guide gives for this reference type, such as text or toc")
+	head := flag.String("head", "", "Extract only the opening of the book: a number of words such as 500w, or of characters such as 2000c")
+	numJobs := flag.Int("jobs", runtime.NumCPU(), "Number of chapters to extract in parallel, or of files to convert in parallel when converting several")
+	format := flag.String("format", epub.FormatText, "Output format: text, json, markdown, or annotated (text with each line prefixed by its source file and element)")
+	frontMatter := flag.Bool("front-matter", false, "Start markdown output with a YAML front matter block of the book's metadata")
+	validate := flag.Bool("validate", false, "Check the structure of each EPUB and report problems without converting")
+	manifest := flag.Bool("manifest", false, "List every manifest item's id, href, and media type and exit without converting")
+	listRenditions := flag.Bool("renditions", false, "List the package documents of a multiple-rendition book, numbered for -rendition, and exit without converting")
+	list := flag.Bool("list", false, "List the chapters that would be extracted and exit without converting")
+	collapseSpaces := flag.Bool("collapse-spaces", true, "Collapse runs of spaces and tabs within lines")
+	trimLines := flag.Bool("trim-lines", true, "Trim whitespace from the ends of lines")
+	keepNewlines := flag.Bool("keep-newlines", false, "Keep line breaks found within the source text")
+	crlf := flag.Bool("crlf", false, "End text output lines with \\r\\n instead of \\n")
+	maxBlankLines := flag.Int("max-blank-lines", 1, "Collapse runs of blank lines in text output to at most this many (-1 keeps them all)")
+	keepIndent := flag.Bool("keep-indent", false, "Keep non-breaking spaces used to indent the start of a line")
+	rubyText := flag.Bool("ruby", false, "Include ruby annotations such as furigana in parentheses after their base text")
+	svgText := flag.Bool("svg-text", false, "Include the text labels of inline SVG drawings")
+	anchorMarkers := flag.Bool("anchors", false, "Mark headings and sections that have an id with [#id]")
+	stripFootnoteRefs := flag.Bool("strip-footnote-refs", false, "Drop footnote reference markers such as superscript note numbers")
+	links := flag.String("links", "", "Keep external link URLs: inline after the link text, or footnotes at the end of each chapter")
+	wrap := flag.Int("wrap", 0, "Hard-wrap text output at this many columns (0 for no wrapping)")
+	dumpHTML := flag.String("dump-html", "", "Also copy each chapter's raw HTML into this directory, for debugging")
+	summary := flag.Bool("summary", false, "Start the output with the title, author, and chapter list with word counts")
+	timeout := flag.Duration("timeout", defaultTimeout, "Time limit for downloading http(s) inputs")
+	userAgent := flag.String("user-agent", "epub2text", "User-Agent header sent when downloading http(s) inputs")
+	showVersion := flag.Bool("version", false, "Print version and build information and exit")
+	maxBytes := flag.Int64("max-uncompressed-bytes", 0, "Abort when the book decompresses to more than this many bytes in total or in any one file (0 for no limit)")
+	quiet := flag.Bool("quiet", false, "Print only warnings and errors, not progress messages")
+	verbose := flag.Bool("verbose", false, "Log each stage of the conversion to stderr")
+	stats := flag.Bool("stats", false, "Print word, character, and chapter counts to stderr")
+	clipboard := flag.Bool("clipboard", false, "Copy the text to the system clipboard instead of writing a file")
+	gzipOutput := flag.Bool("gzip", false, "Compress the output with gzip (default output extension becomes .txt.gz)")
+	configFile := flag.String("config", "", "Read default flag values from this file of name = value lines (default ~/"+defaultConfig+" if it exists)")
+	recursive := flag.Bool("recursive", false, "Convert every .epub found beneath directory inputs, writing each .txt next to its source")
+	flag.Usage = usage
 	flag.Parse()
 
-	// Check if input file is provided
-	if *inputFile == "" {
-		fmt.Println("Error: input file is required")
-		flag.Usage()
+	// Fill in the flags not given on the command line from the config file
+	if err := applyConfig(*configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Set default output file if not provided
-	if *outputFile == "" {
-		baseName := filepath.Base(*inputFile)
-		ext := filepath.Ext(baseName)
-		*outputFile = strings.TrimSuffix(baseName, ext) + ".txt"
+	if *showVersion {
+		printVersion()
+		return
 	}
 
-	fmt.Printf("Converting %s to %s\n", *inputFile, *outputFile)
+	// Collect the input files from -input and any trailing arguments
+	var inputs []string
+	if *inputFile != "" {
+		inputs = append(inputs, *inputFile)
+	}
+	inputs = append(inputs, flag.Args()...)
 
-	// Start the conversion process
-	err := convertEpubToText(*inputFile, *outputFile)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+	// Check if input file is provided
+	if len(inputs) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: input file is required")
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	fmt.Println("Conversion completed successfully")
-}
-
-func convertEpubToText(epubPath, txtPath string) error {
-	// Open the EPUB file (which is a ZIP archive)
-	reader, err := zip.OpenReader(epubPath)
-	if err != nil {
-		return fmt.Errorf("failed to open EPUB file: %w", err)
+	s := &settings{
+		opts: epub.Options{
+			Metadata:         *metadata,
+			Separator:        *separator,
+			TOC:              *toc,
+			ChapterTitles:    *chapterTitles,
+			NumberChapters:   *numberChapters,
+			AltText:          *altText,
+			CaptionPrefix:    *captionPrefix,
+			PageMarkers:      *pageMarkers,
+			IncludeNonLinear: *includeNonLinear,
+			IncludeHidden:    *includeHidden,
+			OnlyBodymatter:   *onlyBodymatter,
+			SplitAnchors:     *splitAnchors,
+			Dedup:            *dedup,
+			Rendition:        *rendition,
+			Force:            *force,
+			Chapter:          *chapter,
+			ChapterHref:      *chapterHref,
+			From:             *from,
+			To:               *to,
+			ContentTypes:     splitList(*contentTypes),
+			StartAt:          *startAt,
+			Jobs:             *numJobs,
+			Format:           *format,
+			FrontMatter:      *frontMatter,
+			Summary:          *summary,
+			Cleanup: &epub.Cleanup{
+				CollapseSpaces: *collapseSpaces,
+				TrimLines:      *trimLines,
+				MaxBlankLines:  *maxBlankLines,
+				KeepNewlines:   *keepNewlines,
+			},
+			KeepIndent:           *keepIndent,
+			RubyText:             *rubyText,
+			SVGText:              *svgText,
+			AnchorMarkers:        *anchorMarkers,
+			StripFootnoteRefs:    *stripFootnoteRefs,
+			Links:                *links,
+			DumpHTML:             *dumpHTML,
+			MaxUncompressedBytes: *maxBytes,
+			Wrap:                 *wrap,
+		},
+		stats:     *stats,
+		gzip:      *gzipOutput,
+		clipboard: *clipboard,
+		crlf:      *crlf,
+		makeDirs:  *outputTemplate != "",
+		quiet:     *quiet,
+		splitDir:  *split,
+		overwrite: *overwrite,
+		timeout:   *timeout,
+		userAgent: *userAgent,
 	}
-	defer reader.Close()
 
-	// Find and parse the container.xml file to get the OPF file
-	var containerFile *zip.File
-	for _, file := range reader.File {
-		if file.Name == "META-INF/container.xml" {
-			containerFile = file
-			break
+	if *head != "" {
+		words, chars, err := parseHead(*head)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
+		s.opts.HeadWords, s.opts.HeadChars = words, chars
 	}
-	if containerFile == nil {
-		return fmt.Errorf("container.xml file not found in EPUB")
+
+	if *verbose {
+		s.opts.Logger = log.New(os.Stderr, "epub2text: ", log.LstdFlags)
+	}
+
+	// Check the template before doing any work
+	if *outputTemplate != "" {
+		if *outputFile != "" {
+			fmt.Fprintln(os.Stderr, "Error: -output and -output-template cannot be used together")
+			os.Exit(1)
+		}
+		if err := checkOutputTemplate(*outputTemplate); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	// Parse container.xml to find the OPF file
-	container, err := parseContainer(containerFile)
+	// Expand directory inputs into the books found beneath them
+	jobs, err := collectJobs(inputs, *recursive, s.outputExt())
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
+	batch := len(jobs) > 1 || *recursive
 
-	if len(container.RootFiles.RootFile) == 0 {
-		return fmt.Errorf("no rootfile found in container.xml")
+	// Place the outputs according to the template
+	if *outputTemplate != "" {
+		for i, j := range jobs {
+			if j.input != "-" {
+				jobs[i].output = expandOutputTemplate(*outputTemplate, j.input)
+			}
+		}
 	}
 
-	// Get the OPF file path
-	opfPath := container.RootFiles.RootFile[0].FullPath
+	// Only check the books when validating
+	if *validate {
+		var errs []error
+		for _, j := range jobs {
+			if err := validateOne(j.input, s); err != nil {
+				// Validation problems have already been listed
+				if !errors.Is(err, errInvalid) {
+					fmt.Fprintf(os.Stderr, "Error: %s: %v\n", j.input, err)
+				}
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			os.Exit(exitCode(errs...))
+		}
+		return
+	}
 
-	// Find the OPF file
-	var opfFile *zip.File
-	for _, file := range reader.File {
-		if file.Name == opfPath {
-			opfFile = file
-			break
+	// Only inspect the books when listing
+	if *list || *manifest || *listRenditions {
+		var errs []error
+		for _, j := range jobs {
+			inspect := listOne
+			if *manifest {
+				inspect = manifestOne
+			}
+			if *listRenditions {
+				inspect = renditionsOne
+			}
+			if err := inspect(j.input, s, batch); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", j.input, err)
+				errs = append(errs, err)
+			}
 		}
+		if len(errs) > 0 {
+			os.Exit(exitCode(errs...))
+		}
+		return
+	}
+
+	// A single output path only makes sense for a single input
+	if batch && *outputFile != "" {
+		fmt.Fprintln(os.Stderr, "Error: -output cannot be used with multiple input files")
+		os.Exit(1)
+	}
+	if *split != "" && (*outputFile != "" || *outputTemplate != "") {
+		fmt.Fprintln(os.Stderr, "Error: -split cannot be used with -output or -output-template")
+		os.Exit(1)
+	}
+	if *clipboard && (batch || *outputFile != "" || *outputTemplate != "" || *split != "" || *gzipOutput) {
+		fmt.Fprintln(os.Stderr, "Error: -clipboard takes a single input and cannot be used with -output, -output-template, -split, or -gzip")
+		os.Exit(1)
 	}
-	if opfFile == nil {
-		return fmt.Errorf("OPF file not found at path: %s", opfPath)
+	s.splitPerBook = batch
+
+	// Convert a single file, failing immediately on error
+	if !batch {
+		output := jobs[0].output
+		if *outputFile != "" {
+			output = *outputFile
+		}
+		if err := convertOne(jobs[0].input, output, s); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
 	}
 
-	// Parse the OPF file to get content ordering
-	pkg, err := parsePackage(opfFile)
-	if err != nil {
-		return err
+	// Convert the files in the batch, continuing past failures
+	errs := convertBatch(jobs, s, *numJobs)
+	s.infof("Converted %d files, %d failed\n", len(jobs)-len(errs), len(errs))
+	if len(errs) > 0 {
+		os.Exit(exitCode(errs...))
 	}
+}
 
-	// Create a base directory for resolving relative paths
-	baseDir := filepath.Dir(opfPath)
+// Exit statuses, so scripts can tell why a conversion failed
+const (
+	exitError   = 1 // any other failure, including bad usage
+	exitIO      = 2 // an input or output file could not be read or written
+	exitInvalid = 3 // the input is not a valid EPUB
+	exitDRM     = 4 // the EPUB is DRM-protected
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -input book.epub [flags] [more.epub ...]\n", filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+	fmt.Fprintf(flag.CommandLine.Output(), `
+Exit status:
+  %d  conversion failed for another reason
+  %d  an input or output file could not be read or written
+  %d  the input is not a valid EPUB
+  %d  the EPUB is DRM-protected
+`, exitError, exitIO, exitInvalid, exitDRM)
+}
 
-	// Create a map of ID to file path
-	idToPath := make(map[string]string)
-	for _, item := range pkg.Manifest.Items {
-		// Only include HTML content
-		if strings.Contains(item.MediaType, "html") || strings.Contains(item.MediaType, "xhtml") {
-			idToPath[item.ID] = filepath.Join(baseDir, item.Href)
+// exitCode classifies conversion errors into an exit status; failures of
+// different kinds in a batch report the generic status
+func exitCode(errs ...error) int {
+	code := 0
+	for _, err := range errs {
+		c := errorCategory(err)
+		if code != 0 && c != code {
+			return exitError
 		}
+		code = c
 	}
+	return max(code, exitError)
+}
 
-	// Get ordered content files
-	var contentPaths []string
-	for _, itemRef := range pkg.Spine.ItemRefs {
-		if path, ok := idToPath[itemRef.IDRef]; ok {
-			contentPaths = append(contentPaths, path)
+func errorCategory(err error) int {
+	var pathErr *fs.PathError
+	var syntaxErr *xml.SyntaxError
+	var unmarshalErr xml.UnmarshalError
+	switch {
+	case errors.Is(err, epub.ErrDRM):
+		return exitDRM
+	case errors.Is(err, errInvalid), errors.Is(err, epub.ErrTooLarge), errors.Is(err, epub.ErrNoContainer), errors.Is(err, epub.ErrNoRootfile), errors.Is(err, epub.ErrOPFNotFound), errors.Is(err, epub.ErrMOBI),
+		errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrAlgorithm),
+		errors.As(err, &syntaxErr), errors.As(err, &unmarshalErr):
+		return exitInvalid
+	case errors.As(err, &pathErr):
+		return exitIO
+	default:
+		return exitError
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
+	return items
+}
 
-	// Extract all content files
-	var textContent strings.Builder
-	for _, contentPath := range contentPaths {
-		// Find the file in the ZIP
-		var contentFile *zip.File
-		for _, file := range reader.File {
-			// Normalize paths for comparison
-			normalizedPath := filepath.ToSlash(file.Name)
-			normalizedContentPath := filepath.ToSlash(contentPath)
-			if normalizedPath == normalizedContentPath {
-				contentFile = file
-				break
+// parseHead reads a -head value, a count followed by "w" for words or
+// "c" for characters
+func parseHead(value string) (words, chars int, err error) {
+	if len(value) >= 2 {
+		n, err := strconv.Atoi(value[:len(value)-1])
+		if err == nil && n > 0 {
+			switch value[len(value)-1] {
+			case 'w':
+				return n, 0, nil
+			case 'c':
+				return 0, n, nil
 			}
 		}
+	}
+	return 0, 0, fmt.Errorf("invalid -head value %q: want a count of words or characters such as 500w or 2000c", value)
+}
 
-		if contentFile == nil {
-			fmt.Printf("Warning: content file not found: %s\n", contentPath)
-			continue
-		}
+// settings holds the conversion options along with CLI-only behavior
+type settings struct {
+	opts         epub.Options
+	stats        bool
+	gzip         bool
+	crlf         bool
+	makeDirs     bool // create missing output directories
+	quiet        bool
+	splitDir     string
+	splitPerBook bool // give each book its own directory within splitDir
+	overwrite    bool // replace existing output files
+	clipboard    bool // copy the text to the clipboard instead of a file
+	timeout      time.Duration
+	userAgent    string
+	stderr       io.Writer // per-file messages such as -stats; nil means os.Stderr
+}
 
-		// Extract text from this content file
-		content, err := extractTextFromHTMLFile(contentFile)
-		if err != nil {
-			fmt.Printf("Warning: error processing %s: %v\n", contentPath, err)
-			continue
-		}
+// infof prints a progress message to stderr unless running quietly
+func (s *settings) infof(format string, args ...any) {
+	if !s.quiet {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// messages returns the writer for per-file messages
+func (s *settings) messages() io.Writer {
+	if s.stderr == nil {
+		return os.Stderr
+	}
+	return s.stderr
+}
 
-		textContent.WriteString(content)
-		textContent.WriteString("\n\n")
+// outputExt is the extension given to derived output paths
+func (s *settings) outputExt() string {
+	if s.gzip {
+		return ".txt.gz"
 	}
+	return ".txt"
+}
 
-	// Write the text content to the output file
-	err = os.WriteFile(txtPath, []byte(textContent.String()), 0644)
+// convertOne converts a single input, deriving the output path when empty
+func convertOne(inputFile, outputFile string, s *settings) error {
+	if s.splitDir != "" {
+		return splitOne(inputFile, s)
+	}
+
+	if outputFile == "" {
+		outputFile = defaultOutputPath(inputFile, s.outputExt())
+	}
+
+	// Progress messages go to stderr so they never mix with text on stdout
+	if s.clipboard {
+		s.infof("Copying %s to the clipboard\n", inputFile)
+	} else {
+		s.infof("Converting %s to %s\n", inputFile, outputFile)
+	}
+
+	// Start the conversion process
+	err := convertEpubToText(inputFile, outputFile, s)
 	if err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
+		return err
 	}
 
+	s.infof("Conversion completed successfully\n")
 	return nil
 }
 
-func parseContainer(containerFile *zip.File) (*Container, error) {
-	reader, err := containerFile.Open()
+// listOne prints the chapters of a single input to stdout, headed by the
+// input's name when several are listed
+func listOne(inputFile string, s *settings, showName bool) error {
+	var entries []epub.SpineEntry
+	err := withInput(inputFile, s, func(epubPath string) error {
+		var err error
+		entries, err = epub.ListFile(epubPath, s.opts)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to open container.xml: %w", err)
+		return err
 	}
-	defer reader.Close()
 
-	data, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read container.xml: %w", err)
+	if showName {
+		fmt.Printf("%s:\n", inputFile)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", entry.Index, entry.IDRef, entry.Path, entry.Title)
 	}
+	return tw.Flush()
+}
 
-	var container Container
-	err = xml.Unmarshal(data, &container)
+// manifestOne prints every manifest item of a single input to stdout,
+// headed by the input's name when several are listed
+func manifestOne(inputFile string, s *settings, showName bool) error {
+	var items []epub.Item
+	err := withInput(inputFile, s, func(epubPath string) error {
+		var err error
+		items, err = epub.ListManifestFile(epubPath, s.opts)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse container.xml: %w", err)
+		return err
 	}
 
-	return &container, nil
+	if showName {
+		fmt.Printf("%s:\n", inputFile)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, item := range items {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", item.ID, item.Href, item.MediaType, item.Properties)
+	}
+	return tw.Flush()
 }
 
-func parsePackage(opfFile *zip.File) (*Package, error) {
-	reader, err := opfFile.Open()
+// renditionsOne prints the package documents of a single input to
+// stdout, numbered as -rendition counts them, headed by the input's name
+// when several are listed
+func renditionsOne(inputFile string, s *settings, showName bool) error {
+	var rootFiles []epub.RootFile
+	err := withInput(inputFile, s, func(epubPath string) error {
+		var err error
+		rootFiles, err = epub.RenditionsFile(epubPath, s.opts)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to open OPF file: %w", err)
+		return err
 	}
-	defer reader.Close()
 
-	data, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read OPF file: %w", err)
+	if showName {
+		fmt.Printf("%s:\n", inputFile)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, rootFile := range rootFiles {
+		var details []string
+		for _, detail := range []string{rootFile.Label, rootFile.Language, rootFile.Layout, rootFile.Media, rootFile.AccessMode} {
+			if detail != "" {
+				details = append(details, detail)
+			}
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", i+1, rootFile.FullPath, strings.Join(details, ", "))
 	}
+	return tw.Flush()
+}
+
+// errInvalid reports that validation found problems with a book
+var errInvalid = errors.New("EPUB failed validation")
 
-	var pkg Package
-	err = xml.Unmarshal(data, &pkg)
+// validateOne prints each structural problem found in a single input to
+// stdout, or that it is valid
+func validateOne(inputFile string, s *settings) error {
+	var problems []string
+	err := withInput(inputFile, s, func(epubPath string) error {
+		var err error
+		problems, err = epub.ValidateFile(epubPath, s.opts)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse OPF file: %w", err)
+		return err
 	}
 
-	return &pkg, nil
+	if len(problems) == 0 {
+		fmt.Printf("%s: OK\n", inputFile)
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", inputFile, problem)
+	}
+	return errInvalid
 }
 
-func extractTextFromHTMLFile(htmlFile *zip.File) (string, error) {
-	reader, err := htmlFile.Open()
-	if err != nil {
-		return "", fmt.Errorf("failed to open HTML file: %w", err)
+// defaultOutputPath derives the output file from the input filename,
+// written to the current directory; stdin input defaults to stdout
+func defaultOutputPath(inputFile, outputExt string) string {
+	if inputFile == "-" {
+		return "-"
 	}
-	defer reader.Close()
-
-	// Parse HTML
-	doc, err := html.Parse(reader)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	baseName := filepath.Base(inputFile)
+	if isURL(inputFile) {
+		baseName = urlBaseName(inputFile)
 	}
+	ext := filepath.Ext(baseName)
+	return sanitizeFileName(strings.TrimSuffix(baseName, ext)) + outputExt
+}
 
-	// Extract text
-	var textBuilder strings.Builder
-	extractText(doc, &textBuilder)
+// windowsReserved are the device names Windows refuses as filenames,
+// with or without an extension
+var windowsReserved = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[0-9]|lpt[0-9])$`)
+
+// sanitizeFileName makes name usable as a filename on any platform,
+// replacing the characters Windows forbids and avoiding its reserved
+// device names
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
 
-	// Clean up the text
-	text := textBuilder.String()
+	// Windows drops trailing dots and spaces, which would change the name
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "output"
+	}
+	if windowsReserved.MatchString(name) {
+		return "_" + name
+	}
+	return name
+}
 
-	// Remove excessive whitespace
-	space := regexp.MustCompile(`\s+`)
-	text = space.ReplaceAllString(text, " ")
+func convertEpubToText(epubPath, txtPath string, s *settings) error {
+	// Tally each chapter as it is written when statistics were requested
+	opts := s.opts
+	var st textStats
+	if s.stats {
+		opts.OnChapter = st.add
+	}
 
-	// Remove leading/trailing whitespace from lines
-	var cleanLines []string
-	for _, line := range strings.Split(text, "\n") {
-		cleanLine := strings.TrimSpace(line)
-		if cleanLine != "" {
-			cleanLines = append(cleanLines, cleanLine)
+	switch {
+	case s.clipboard:
+		// Collect the text content for the clipboard when requested
+		var buf bytes.Buffer
+		if err := convertTo(&buf, epubPath, opts, s); err != nil {
+			return err
+		}
+		if err := copyToClipboard(buf.Bytes()); err != nil {
+			return err
+		}
+	case txtPath == "-":
+		// Write the text content to stdout when requested
+		w := bufio.NewWriter(os.Stdout)
+		if err := convertTo(w, epubPath, opts, s); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("failed to write to stdout: %w", err)
+		}
+	default:
+		if s.makeDirs {
+			if err := os.MkdirAll(filepath.Dir(txtPath), 0o755); err != nil {
+				return fmt.Errorf("failed to create output directory: %w", err)
+			}
+		}
+
+		// Stream the text content into the output file chapter by chapter
+		err := createOutput(txtPath, s.overwrite, func(w io.Writer) error {
+			return convertTo(w, epubPath, opts, s)
+		})
+		if err != nil {
+			return err
 		}
 	}
 
-	return strings.Join(cleanLines, "\n"), nil
+	if s.stats {
+		st.print(s.messages())
+	}
+	return nil
 }
 
-func extractText(n *html.Node, builder *strings.Builder) {
-	if n.Type == html.TextNode {
-		text := strings.TrimSpace(n.Data)
-		if text != "" {
-			builder.WriteString(text)
-			builder.WriteString(" ")
-		}
+// createOutput creates the file at path and fills it with write,
+// removing the file again if write fails
+func createOutput(path string, overwrite bool, write func(w io.Writer) error) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	file, err := os.OpenFile(path, flags, 0o666)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("failed to write output file: %w (use -overwrite to replace it)", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Don't leave a partial output file behind
+		os.Remove(path)
+		return err
 	}
+	return nil
+}
 
-	// Check if this node is a block element that should add a line break
-	if n.Type == html.ElementNode {
-		switch n.Data {
-		case "p", "div", "h1", "h2", "h3", "h4", "h5", "h6", "li", "br", "hr":
-			builder.WriteString("\n")
-		}
+// convertTo streams the converted input to w, normalizing line endings
+// and gzip-compressing it as requested
+func convertTo(w io.Writer, epubPath string, opts epub.Options, s *settings) error {
+	return writeTo(w, opts, s, func(w io.Writer) error {
+		return convertInput(w, epubPath, opts, s)
+	})
+}
+
+// writeTo calls write with a writer that normalizes line endings and
+// gzip-compresses the output on its way to w, as requested
+func writeTo(w io.Writer, opts epub.Options, s *settings, write func(w io.Writer) error) error {
+	if !s.gzip {
+		return write(textOutput(w, opts, s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if err := write(textOutput(gz, opts, s)); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress output: %w", err)
 	}
+	return nil
+}
 
-	// Process child nodes
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		extractText(c, builder)
+// textOutput applies the -crlf pass to text output. Blank lines are
+// limited as each chapter is cleaned up, where preformatted text is
+// known and left alone.
+func textOutput(w io.Writer, opts epub.Options, s *settings) io.Writer {
+	if opts.Format == epub.FormatJSON || !s.crlf {
+		return w
 	}
+	return epub.NewlineWriter(w, "\r\n")
+}
 
-	// Add additional line breaks after certain elements
-	if n.Type == html.ElementNode {
-		switch n.Data {
-		case "p", "div", "h1", "h2", "h3", "h4", "h5", "h6", "li":
-			builder.WriteString("\n")
-		}
+// convertInput streams the converted input to w
+func convertInput(w io.Writer, epubPath string, opts epub.Options, s *settings) error {
+	if epubPath == "-" {
+		// The whole archive is buffered in memory since ZIP needs random
+		// access, so very large books read from stdin cost their full size
+		return epub.ConvertTo(w, os.Stdin, opts)
 	}
+
+	epubPath, cleanup, err := localPath(epubPath, s)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return epub.ConvertFileTo(w, epubPath, opts)
+}
+
+// textStats accumulates the size of the extracted text
+type textStats struct {
+	words, chars, lines, chapters int
+}
+
+func (st *textStats) add(chapter epub.Chapter) {
+	w, c, l := epub.Stats(chapter.Text)
+	st.words += w
+	st.chars += c
+	st.lines += l
+	st.chapters++
+}
+
+// print reports the statistics to w
+func (st *textStats) print(w io.Writer) {
+	fmt.Fprintf(w, "Words: %d\nCharacters: %d\nLines: %d\nChapters: %d\n", st.words, st.chars, st.lines, st.chapters)
 }
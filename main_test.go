@@ -0,0 +1,72 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nealhardesty/epub2text/epub"
+)
+
+func TestExitCode(t *testing.T) {
+	pathErr := &fs.PathError{Op: "open", Path: "book.epub", Err: fs.ErrNotExist}
+	syntaxErr := &xml.SyntaxError{Msg: "unexpected EOF", Line: 1}
+
+	tests := []struct {
+		name string
+		errs []error
+		want int
+	}{
+		{"DRM", []error{epub.ErrDRM}, exitDRM},
+		{"wrapped DRM", []error{fmt.Errorf("book.epub: %w", epub.ErrDRM)}, exitDRM},
+		{"not a zip", []error{fmt.Errorf("failed to open EPUB: %w", zip.ErrFormat)}, exitInvalid},
+		{"bad XML", []error{fmt.Errorf("failed to parse OPF: %w", syntaxErr)}, exitInvalid},
+		{"no container", []error{epub.ErrNoContainer}, exitInvalid},
+		{"MOBI", []error{epub.ErrMOBI}, exitInvalid},
+		{"missing file", []error{fmt.Errorf("failed to open EPUB: %w", pathErr)}, exitIO},
+		{"other", []error{errors.New("something went wrong")}, exitError},
+		{"batch of one kind", []error{epub.ErrDRM, epub.ErrDRM}, exitDRM},
+		{"mixed batch", []error{epub.ErrDRM, pathErr, zip.ErrFormat}, exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.errs...); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.errs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertOneExitCode(t *testing.T) {
+	dir := t.TempDir()
+	notZip := filepath.Join(dir, "book.epub")
+	if err := os.WriteFile(notZip, []byte("not a zip archive"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{"missing file", filepath.Join(dir, "missing.epub"), exitIO},
+		{"not an EPUB", notZip, exitInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &settings{quiet: true}
+			err := convertOne(tt.input, filepath.Join(dir, tt.name+".txt"), s)
+			if err == nil {
+				t.Fatal("conversion succeeded")
+			}
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", err, got, tt.want)
+			}
+		})
+	}
+}
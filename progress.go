@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// progress reports how far a batch conversion has got on stderr: as a
+// single line rewritten in place on a terminal, or one line per file
+// otherwise. It is safe for concurrent use.
+type progress struct {
+	mu    sync.Mutex
+	total int
+	count int
+	tty   bool
+	quiet bool
+}
+
+func newProgress(total int, quiet bool) *progress {
+	return &progress{total: total, tty: isTerminal(os.Stderr), quiet: quiet}
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// next reports that conversion of the named file is starting
+func (p *progress) next(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count++
+	if p.quiet {
+		return
+	}
+	if p.tty {
+		fmt.Fprintf(os.Stderr, "\r\033[K[%d/%d] converting %s", p.count, p.total, name)
+	} else {
+		fmt.Fprintf(os.Stderr, "[%d/%d] converting %s\n", p.count, p.total, name)
+	}
+}
+
+// print writes message to stderr on a line clear of the progress line
+func (p *progress) print(message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Fprint(os.Stderr, message)
+}
+
+// clear erases the progress line so other messages start on a clean line
+func (p *progress) clear() {
+	if p.tty && !p.quiet {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
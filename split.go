@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/nealhardesty/epub2text/epub"
+)
+
+// splitDir returns the directory that receives the chapters of
+// inputFile: the -split directory itself, or a subdirectory named after
+// the book when converting several
+func splitDir(inputFile string, s *settings) string {
+	if !s.splitPerBook {
+		return s.splitDir
+	}
+	return filepath.Join(s.splitDir, defaultOutputPath(inputFile, ""))
+}
+
+// splitOne writes each chapter of a single input to its own numbered
+// file, named with the chapter's title when chapter titles are enabled
+func splitOne(inputFile string, s *settings) error {
+	dir := splitDir(inputFile, s)
+	s.infof("Converting %s to chapters in %s\n", inputFile, dir)
+
+	// Each file holds just its chapter, without the book-level header
+	chapterOpts := s.opts
+	chapterOpts.Metadata = false
+	chapterOpts.TOC = false
+	chapterOpts.Summary = false
+
+	var st textStats
+	writeChapter := func(chapter epub.Chapter) error {
+		// The directory is created with the first chapter, so books that
+		// fail to open leave nothing behind
+		if st.chapters == 0 {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return fmt.Errorf("failed to create output directory: %w", err)
+			}
+		}
+
+		name := fmt.Sprintf("%03d", chapter.Index)
+		if slug := slugify(chapter.Title); slug != "" && s.opts.ChapterTitles {
+			name += "-" + slug
+		}
+
+		book := &epub.Book{Chapters: []epub.Chapter{chapter}}
+		text, err := book.Render(chapterOpts)
+		if err != nil {
+			return err
+		}
+		err = createOutput(filepath.Join(dir, name+s.outputExt()), s.overwrite, func(w io.Writer) error {
+			return writeTo(w, chapterOpts, s, func(w io.Writer) error {
+				_, err := io.WriteString(w, text)
+				return err
+			})
+		})
+		if err != nil {
+			return err
+		}
+		st.add(chapter)
+		return nil
+	}
+
+	if err := visitInput(inputFile, s, writeChapter); err != nil {
+		return err
+	}
+
+	if s.stats {
+		st.print(s.messages())
+	}
+	s.infof("Wrote %d chapters\n", st.chapters)
+	return nil
+}
+
+// visitInput calls fn with each chapter of the input in reading order
+func visitInput(inputFile string, s *settings, fn func(epub.Chapter) error) error {
+	if inputFile == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read EPUB: %w", err)
+		}
+		return epub.ConvertWithVisitor(bytes.NewReader(data), int64(len(data)), s.opts, fn)
+	}
+
+	epubPath, cleanup, err := localPath(inputFile, s)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return epub.ConvertFileWithVisitor(epubPath, s.opts, fn)
+}
+
+// slugify turns a title into a lowercase, hyphen-separated filename part
+func slugify(title string) string {
+	var slug strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+
+	// Keep filenames to a manageable length
+	runes := []rune(slug.String())
+	if len(runes) > 50 {
+		runes = runes[:50]
+	}
+	return strings.TrimRight(string(runes), "-")
+}
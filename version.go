@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// version is set at build time with
+// -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// printVersion writes the version and, when the binary carries build
+// information, the module version and VCS revision it was built from
+func printVersion() {
+	fmt.Printf("epub2text %s\n", version)
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		fmt.Printf("module: %s %s\n", info.Main.Path, info.Main.Version)
+	}
+
+	settings := make(map[string]string)
+	for _, setting := range info.Settings {
+		settings[setting.Key] = setting.Value
+	}
+	if revision := settings["vcs.revision"]; revision != "" {
+		if settings["vcs.modified"] == "true" {
+			revision += " (modified)"
+		}
+		fmt.Printf("revision: %s\n", revision)
+	}
+	if buildTime := settings["vcs.time"]; buildTime != "" {
+		fmt.Printf("commit time: %s\n", buildTime)
+	}
+	fmt.Printf("go: %s\n", info.GoVersion)
+}